@@ -0,0 +1,151 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+)
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, nv := range args {
+		values[i] = nv.Value
+	}
+	return values
+}
+
+// Arguments passed as sql.Named() to a stub are matched by name, everything else by position.
+func expectedArgs(args []interface{}) []driver.NamedValue {
+	expected := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		nv := driver.NamedValue{Ordinal: i + 1, Value: arg}
+		if named, ok := arg.(sql.NamedArg); ok {
+			nv.Name = named.Name
+			nv.Value = named.Value
+		}
+		expected[i] = nv
+	}
+	return expected
+}
+
+// Expected arguments are converted the same way the arguments of a call are, so 1 matches int64(1).
+func (c *conn) argsMatch(expected, actual []driver.NamedValue) bool {
+	if len(expected) != len(actual) {
+		return false
+	}
+
+	for _, e := range expected {
+		var a *driver.NamedValue
+		if e.Name != "" {
+			for i := range actual {
+				if actual[i].Name == e.Name {
+					a = &actual[i]
+				}
+			}
+		} else {
+			a = &actual[e.Ordinal-1]
+		}
+
+		if a == nil {
+			return false
+		}
+		if v, err := c.convertArg(e.Value); err != nil || !reflect.DeepEqual(v, a.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds a stub to the registry, replacing any stub for the same query and arguments.
+func (c *Connector) stub(sql string, q *query) {
+	q.usage = &StubUsage{Kind: "stub", SQL: sql, Args: q.args, Location: callerLocation()}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.register(q.usage)
+	q.sql = sql
+	hash := getQueryHash(sql)
+	for i, existing := range c.queries[hash] {
+		if tokensEqual(existing.sql, sql) && reflect.DeepEqual(existing.args, q.args) && existing.scenario == q.scenario && existing.state == q.state {
+			c.queries[hash][i] = q
+			return
+		}
+	}
+	c.queries[hash] = append(c.queries[hash], q)
+}
+
+// Returns the stub for a query, preferring one whose arguments match over one that matches any arguments, and a scenario stub for the current state over a plain one.
+func (c *conn) lookup(sql string, args []driver.NamedValue) *query {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var match, argsMatch *query
+	for _, q := range c.queries[getQueryHash(sql)] {
+		if !c.config.matches(q.sql, sql) || q.scenario != nil && q.scenario.State() != q.state {
+			continue
+		}
+		if q.args == nil {
+			match = preferStub(match, q)
+		} else if c.argsMatch(q.args, args) {
+			argsMatch = preferStub(argsMatch, q)
+		}
+	}
+
+	if argsMatch != nil {
+		return argsMatch
+	}
+	return match
+}
+
+// Later stubs win, except that a plain stub never replaces a scenario stub.
+func preferStub(current, q *query) *query {
+	if current != nil && current.scenario != nil && q.scenario == nil {
+		return current
+	}
+	return q
+}
+
+// Reports whether anything at all has been stubbed for a query.
+func (c *Connector) stubbed(sql string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queries[getQueryHash(sql)]) > 0
+}
+
+// Implements driver.NamedValueChecker. Arguments are left alone if their type was passed to PassThroughArgs(), converted by a converter registered with RegisterArgConverter() or SetArgConverter(), and otherwise handed back to database/sql's default conversion.
+func (c *conn) CheckNamedValue(nv *driver.NamedValue) error {
+	t := reflect.TypeOf(nv.Value)
+
+	if c.passThroughArgs[t] {
+		return nil
+	}
+
+	var err error
+	if convert, ok := c.argConverters[t]; ok {
+		nv.Value, err = convert(nv.Value)
+		return err
+	}
+	if c.argConverter != nil {
+		nv.Value, err = c.argConverter.ConvertValue(nv.Value)
+		return err
+	}
+
+	return driver.ErrSkip
+}
+
+func (c *conn) convertArg(v interface{}) (driver.Value, error) {
+	nv := driver.NamedValue{Value: v}
+	if err := c.CheckNamedValue(&nv); err != driver.ErrSkip {
+		return nv.Value, err
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
@@ -0,0 +1,202 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestSetQueryWithNamedArgsFunc(t *testing.T) {
+	defer Reset()
+
+	var named []driver.NamedValue
+	SetQueryWithNamedArgsFunc(func(query string, args []driver.NamedValue) (driver.Rows, error) {
+		named = args
+		return RowsFromCSVString([]string{"name"}, "joe"), nil
+	})
+
+	db, _ := sql.Open("testdb", "")
+
+	var name string
+	db.QueryRow("SELECT name FROM users WHERE id = @id", sql.Named("id", 2)).Scan(&name)
+
+	if len(named) != 1 || named[0].Name != "id" || named[0].Ordinal != 1 || named[0].Value != int64(2) {
+		t.Fatalf("named argument not passed to query func: %v", named)
+	}
+}
+
+func TestStubQueryWithArgs(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "SELECT name FROM users WHERE id = ?"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "anyone"))
+	StubQueryWithArgs(query, RowsFromCSVString([]string{"name"}, "tim"), 1)
+	StubQueryWithArgs(query, RowsFromCSVString([]string{"name"}, "joe"), 2)
+
+	tests := map[int]string{1: "tim", 2: "joe", 3: "anyone"}
+	for id, expected := range tests {
+		var name string
+		if err := db.QueryRow(query, id).Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name != expected {
+			t.Fatalf("expected %s for id %d, got %s", expected, id, name)
+		}
+	}
+}
+
+func TestStubQueryWithNamedArgs(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "SELECT name FROM users WHERE id = @id AND active = @active"
+	StubQueryWithArgs(query, RowsFromCSVString([]string{"name"}, "tim"), sql.Named("active", true), sql.Named("id", 1))
+
+	var name string
+	if err := db.QueryRow(query, sql.Named("id", 1), sql.Named("active", true)).Scan(&name); err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "tim" {
+		t.Fatal("stub with named arguments did not match")
+	}
+
+	if err := db.QueryRow(query, sql.Named("id", 2), sql.Named("active", true)).Scan(&name); err == nil {
+		t.Fatal("stub with named arguments should not match different arguments")
+	}
+}
+
+func TestStubExecWithArgs(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "UPDATE users SET name = ? WHERE id = ?"
+	StubExecWithArgs(query, NewResult(0, nil, 1, nil), "tim", 1)
+
+	res, err := db.Exec(query, "tim", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if affected, _ := res.RowsAffected(); affected != 1 {
+		t.Fatal("stubbed exec did not return expected result")
+	}
+
+	if _, err := db.Exec(query, "joe", 1); err == nil {
+		t.Fatal("exec with different arguments should not be stubbed")
+	}
+}
+
+func TestQueryLog(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	StubExec("DELETE FROM users WHERE id = :id", NewResult(0, nil, 1, nil))
+	db.Exec("DELETE FROM users WHERE id = :id", sql.Named("id", 5))
+	db.Query("SELECT * FROM users")
+
+	log := QueryLog()
+	if len(log) != 2 {
+		t.Fatalf("expected 2 logged queries, got %d", len(log))
+	}
+
+	if !log[0].Exec || log[0].Args[0].Name != "id" || log[0].Err != nil {
+		t.Fatalf("exec not logged with named args: %v", log[0])
+	}
+
+	if log[1].Exec || log[1].Err == nil {
+		t.Fatalf("unstubbed query not logged with its error: %v", log[1])
+	}
+}
+
+type uuid [2]uint64
+
+func (u uuid) Value() (driver.Value, error) {
+	return "uuid-string", nil
+}
+
+func TestPassThroughArgs(t *testing.T) {
+	defer Reset()
+
+	PassThroughArgs(uuid{})
+
+	var arg interface{}
+	SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+		arg = args[0]
+		return NewResult(0, nil, 1, nil), nil
+	})
+
+	db, _ := sql.Open("testdb", "")
+	db.Exec("DELETE FROM users WHERE id = ?", uuid{1, 2})
+
+	if arg != (uuid{1, 2}) {
+		t.Fatalf("argument was not passed through untouched: %#v", arg)
+	}
+}
+
+func TestPassThroughArgsStubMatching(t *testing.T) {
+	defer Reset()
+
+	PassThroughArgs(uuid{})
+
+	query := "SELECT name FROM users WHERE id = ?"
+	StubQueryWithArgs(query, RowsFromCSVString([]string{"name"}, "tim"), uuid{1, 2})
+
+	db, _ := sql.Open("testdb", "")
+
+	var name string
+	if err := db.QueryRow(query, uuid{1, 2}).Scan(&name); err != nil || name != "tim" {
+		t.Fatalf("stub did not match passed through argument: %v", err)
+	}
+}
+
+type status int
+
+func TestRegisterArgConverter(t *testing.T) {
+	defer Reset()
+
+	RegisterArgConverter(status(0), func(v interface{}) (driver.Value, error) {
+		return []string{"pending", "shipped"}[v.(status)], nil
+	})
+
+	var arg interface{}
+	SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+		arg = args[0]
+		return NewResult(0, nil, 1, nil), nil
+	})
+
+	db, _ := sql.Open("testdb", "")
+	db.Exec("UPDATE orders SET status = ?", status(1))
+
+	if arg != "shipped" {
+		t.Fatalf("registered converter was not applied: %#v", arg)
+	}
+}
+
+type rejectStrings struct{}
+
+func (rejectStrings) ConvertValue(v interface{}) (driver.Value, error) {
+	if _, ok := v.(string); ok {
+		return nil, errors.New("strings not supported")
+	}
+	return driver.DefaultParameterConverter.ConvertValue(v)
+}
+
+func TestSetArgConverter(t *testing.T) {
+	defer Reset()
+
+	SetArgConverter(rejectStrings{})
+	StubExec("UPDATE users SET name = ?", NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+
+	if _, err := db.Exec("UPDATE users SET name = ?", "tim"); err == nil {
+		t.Fatal("argument converter was not used")
+	}
+}
@@ -0,0 +1,183 @@
+package testdb
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How queries are compared to stubs.
+const (
+	// Whitespace is removed and the query lowercased, the default
+	NormalizeWhitespace = "whitespace"
+	// The query is split into tokens, keywords and identifiers are lowercased but string literals are compared as written
+	NormalizeTokens = "tokens"
+	// Queries must match the stub exactly
+	NormalizeExact = "exact"
+)
+
+// Which placeholder syntax is counted when placeholder counting is enabled.
+const (
+	PlaceholdersAny      = "any"
+	PlaceholdersQuestion = "question"
+	PlaceholdersDollar   = "dollar"
+	PlaceholdersNamed    = "named"
+	PlaceholdersAt       = "at"
+)
+
+// Per connection behavior, taken from the Connector and overridden by DSN options.
+type connConfig struct {
+	strict       bool
+	latency      time.Duration
+	normalize    string
+	placeholders string
+	// LastInsertId returns an error, as it does on Postgres
+	noLastInsertId bool
+}
+
+func defaultConfig() connConfig {
+	return connConfig{normalize: NormalizeWhitespace, placeholders: PlaceholdersAny}
+}
+
+// Splits a DSN like "replica?strict=true&latency=5ms" into its name and options.
+func splitDSN(dsn string) (string, string) {
+	if i := strings.IndexByte(dsn, '?'); i >= 0 {
+		return dsn[:i], dsn[i+1:]
+	}
+	return dsn, ""
+}
+
+// Applies the options of a DSN to the config, any option that isn't understood is an error.
+func (cfg *connConfig) apply(options string) error {
+	values, err := url.ParseQuery(options)
+	if err != nil {
+		return fmt.Errorf("testdb: invalid DSN options %q: %v", options, err)
+	}
+
+	for key, vals := range values {
+		v := vals[len(vals)-1]
+
+		switch key {
+		case "strict":
+			if cfg.strict, err = strconv.ParseBool(v); err != nil {
+				return fmt.Errorf("testdb: invalid value %q for DSN option strict", v)
+			}
+		case "latency":
+			if cfg.latency, err = time.ParseDuration(v); err != nil {
+				return fmt.Errorf("testdb: invalid value %q for DSN option latency", v)
+			}
+		case "normalize":
+			switch v {
+			case NormalizeWhitespace, NormalizeTokens, NormalizeExact:
+				cfg.normalize = v
+			default:
+				return fmt.Errorf("testdb: invalid value %q for DSN option normalize", v)
+			}
+		case "placeholders":
+			switch v {
+			case PlaceholdersAny, PlaceholdersQuestion, PlaceholdersDollar, PlaceholdersNamed, PlaceholdersAt:
+				cfg.placeholders = v
+			default:
+				return fmt.Errorf("testdb: invalid value %q for DSN option placeholders", v)
+			}
+		case "lastinsertid":
+			supported, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("testdb: invalid value %q for DSN option lastinsertid", v)
+			}
+			cfg.noLastInsertId = !supported
+		default:
+			return fmt.Errorf("testdb: unknown DSN option %q", key)
+		}
+	}
+
+	return nil
+}
+
+// Reports whether a query matches the SQL a stub was registered with, both already share a query hash.
+func (cfg connConfig) matches(stubbed, query string) bool {
+	switch cfg.normalize {
+	case NormalizeTokens:
+		return tokensEqual(stubbed, query)
+	case NormalizeExact:
+		return stubbed == query
+	}
+	return true
+}
+
+func tokensEqual(a, b string) bool {
+	return strings.Join(tokenize(a), " ") == strings.Join(tokenize(b), " ")
+}
+
+// Waits for the configured latency, or until ctx is done.
+func (cfg connConfig) delay(ctx context.Context) error {
+	if cfg.latency <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(cfg.latency)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Splits a query into tokens, dropping whitespace and comments. Keywords and identifiers are lowercased while string literals and quoted identifiers are kept as written.
+func tokenize(query string) []string {
+	var tokens []string
+
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+		case c == '\'' || c == '"' || c == '`':
+			j := i + 1
+			for j < len(query) {
+				if query[j] == c {
+					// A doubled quote is an escaped quote inside the literal
+					if j+1 < len(query) && query[j+1] == c {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			if j >= len(query) {
+				j = len(query) - 1
+			}
+			tokens = append(tokens, query[i:j+1])
+			i = j
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+		case isIdentChar(c) || c >= 0x80:
+			j := i
+			for j < len(query) && (isIdentChar(query[j]) || query[j] >= 0x80 || query[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, strings.ToLower(query[i:j]))
+			i = j - 1
+		default:
+			tokens = append(tokens, string(c))
+		}
+	}
+
+	return tokens
+}
@@ -0,0 +1,102 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDSNUnknownOption(t *testing.T) {
+	db, _ := sql.Open("testdb", "replica?strict=true&colour=blue")
+
+	if err := db.Ping(); err == nil || err.Error() != `testdb: unknown DSN option "colour"` {
+		t.Fatalf("unknown DSN option should fail, got %v", err)
+	}
+}
+
+func TestDSNInvalidOption(t *testing.T) {
+	db, _ := sql.Open("testdb", "?latency=soon")
+
+	if err := db.Ping(); err == nil || !strings.Contains(err.Error(), "latency") {
+		t.Fatalf("invalid DSN option should fail, got %v", err)
+	}
+}
+
+func TestDSNStrictPlaceholders(t *testing.T) {
+	defer Reset()
+
+	query := "SELECT data->'tags' ? 'go' FROM posts WHERE id = $1"
+	StubQuery(query, RowsFromCSVString([]string{"tagged"}, "true"))
+
+	db, _ := sql.Open("testdb", "?strict=true&placeholders=dollar")
+
+	rows, err := db.Query(query, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if _, err := db.Query(query); err == nil {
+		t.Fatal("strict DSN option should check the number of arguments")
+	}
+}
+
+func TestDSNNormalizeTokens(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT id FROM users WHERE name = 'Tim'", RowsFromCSVString([]string{"id"}, "1"))
+
+	db, _ := sql.Open("testdb", "?normalize=tokens")
+
+	rows, err := db.Query("select id\n  from users where name='Tim'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	if _, err := db.Query("SELECT id FROM users WHERE name = 'tim'"); err == nil {
+		t.Fatal("string literals should be compared as written when normalizing tokens")
+	}
+
+	// The same DSN name without options keeps the default normalization
+	defaultDB, _ := sql.Open("testdb", "")
+	rows, err = defaultDB.Query("SELECT id FROM users WHERE name = 'tim'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+}
+
+func TestDSNLatency(t *testing.T) {
+	defer Reset()
+
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "?latency=20ms")
+	db.Ping()
+
+	start := time.Now()
+	db.Exec("DELETE FROM users")
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("latency DSN option was not applied")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if _, err := db.ExecContext(ctx, "DELETE FROM users"); err != context.DeadlineExceeded {
+		t.Fatalf("latency should stop when the context is done, got %v", err)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("SELECT u.id, 'It''s' -- comment\nFROM \"Users\" u /* alias */ WHERE id>=$1")
+	expected := []string{"select", "u.id", ",", "'It''s'", "from", `"Users"`, "u", "where", "id", ">", "=", "$", "1"}
+
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Fatalf("unexpected tokens %q", tokens)
+	}
+}
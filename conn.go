@@ -1,77 +1,158 @@
 package testdb
 
 import (
+	"context"
 	"database/sql/driver"
-	"errors"
 )
 
+// A connection handed out by a Connector, all stubs live on the Connector so every connection from it sees them.
 type conn struct {
-	queries      map[string]query
-	queryFunc    func(query string, args []driver.Value) (driver.Rows, error)
-	execFunc     func(query string, args []driver.Value) (driver.Result, error)
-	beginFunc    func() (driver.Tx, error)
-	commitFunc   func() error
-	rollbackFunc func() error
+	*Connector
+	id      int64
+	dsn     string
+	config  connConfig
+	closed  bool
+	invalid bool
+	// Opened by the driver from a DSN rather than straight from a Connector, see stale()
+	routed bool
+	// Name the driver looked the Connector up by, which an interceptor may have reported under a different DSN
+	route string
+
+	// Most recent transaction started on this connection, savepoint statements are applied to it while it's open
+	tx *Tx
 }
 
 func newConn() *conn {
-	return &conn{
-		queries: make(map[string]query),
-	}
+	c := NewConnector()
+	return &conn{Connector: c, config: c.config}
 }
 
 func (c *conn) Prepare(query string) (driver.Stmt, error) {
-	s := new(stmt)
+	return c.PrepareContext(context.Background(), query)
+}
 
-	if c.queryFunc != nil {
-		s.queryFunc = func(args []driver.Value) (driver.Rows, error) {
-			return c.queryFunc(query, args)
-		}
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	call := &Call{Op: OpPrepare, ConnID: c.id, DSN: c.dsn, Query: query}
+	c.intercept(call, func(call *Call) {
+		call.Stmt, call.Err = c.prepare(ctx, call.Query)
+	})
+	return call.Stmt, call.error(call.Stmt == nil)
+}
+
+func (c *conn) prepare(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := c.config.delay(ctx); err != nil {
+		return nil, err
 	}
 
-	if c.execFunc != nil {
-		s.execFunc = func(args []driver.Value) (driver.Result, error) {
-			return c.execFunc(query, args)
-		}
+	if err := c.attempt(query); err != nil {
+		return nil, err
 	}
 
-	if q, ok := d.conn.queries[getQueryHash(query)]; ok {
-		if s.queryFunc == nil && q.rows != nil {
-			s.queryFunc = func(args []driver.Value) (driver.Rows, error) {
-				if q.rows != nil {
-					if rows, ok := q.rows.(*rows); ok {
-						return rows.clone(), nil
-					}
-					return q.rows, nil
-				}
-				return nil, q.err
-			}
-		}
+	_, _, savepoint := parseSavepoint(query)
+	savepoint = savepoint && c.openTx() != nil
 
-		if s.execFunc == nil && q.result != nil {
-			s.execFunc = func(args []driver.Value) (driver.Result, error) {
-				if q.result != nil {
-					return q.result, nil
-				}
-				return nil, q.err
-			}
+	if !savepoint && !c.hasHandlers() && !c.stubbed(query) && !c.handlesTable(query) {
+		if err := c.notStubbedPrepare(query); err != nil {
+			return new(stmt), err
 		}
 	}
 
-	if s.queryFunc == nil && s.execFunc == nil {
-		return new(stmt), errors.New("Query not stubbed: " + query)
+	s := &stmt{
+		numInput: c.numInput(query),
+		queryFunc: func(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+			return c.query(ctx, query, args)
+		},
+		execFunc: func(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+			return c.exec(ctx, query, args)
+		},
 	}
+	c.trackStmt(s)
 
 	return s, nil
 }
 
-func (*conn) Close() error {
+func (c *conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed && c.id > 0 {
+		c.openConns--
+		delete(c.conns, c.id)
+	}
+	c.closed = true
+	return nil
+}
+
+// Implements driver.Pinger.
+func (c *conn) Ping(ctx context.Context) error {
+	if err := c.config.delay(ctx); err != nil {
+		return err
+	}
+
+	if c.pingFunc != nil {
+		return c.pingFunc()
+	}
+	return nil
+}
+
+// Implements driver.SessionResetter, database/sql calls it before reusing a connection from the pool.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if c.stale() {
+		return driver.ErrBadConn
+	}
+	if c.resetFunc != nil {
+		return c.resetFunc()
+	}
 	return nil
 }
 
+// Implements driver.Validator, database/sql discards connections that aren't valid instead of returning them to the pool.
+func (c *conn) IsValid() bool {
+	if c.stale() {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.invalid
+}
+
+// Reports whether the DSN the connection was opened with now routes to a different Connector, which happens to every pooled connection after Reset(). Stale connections are dropped by the pool so a *sql.DB opened before Reset() sees the stubs registered after it.
+func (c *conn) stale() bool {
+	if !c.routed {
+		return false
+	}
+
+	current := d.connector(c.route)
+	if current == nil && d.conn != nil {
+		current = d.conn.Connector
+	}
+	return current != c.Connector
+}
+
 func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.interceptBegin(ctx, opts)
+}
+
+func (c *conn) begin(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := c.config.delay(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := c.attemptBegin(); err != nil {
+		return nil, err
+	}
+
 	if c.beginFunc != nil {
-		return c.beginFunc()
+		tx, err := c.beginFunc()
+		if t, ok := tx.(*Tx); ok && err == nil {
+			c.trackTx(ctx, t)
+		}
+		return tx, err
 	}
 
 	t := &Tx{}
@@ -81,35 +162,190 @@ func (c *conn) Begin() (driver.Tx, error) {
 	if c.rollbackFunc != nil {
 		t.SetRollbackFunc(c.rollbackFunc)
 	}
+	for op, errs := range c.savepointErrs {
+		for name, err := range errs {
+			t.stubSavepointError(op, name, err)
+		}
+	}
+	c.trackTx(ctx, t)
 
 	return t, nil
 }
 
+func (c *conn) trackTx(ctx context.Context, t *Tx) {
+	t.createdAt = callerLocation()
+	t.ctx = ctx
+	t.conn = c
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t.commitGate = c.commitGate
+	c.tx = t
+	c.txs = append(c.txs, t)
+}
+
+// Returns the transaction started on this conn if it's still open.
+func (c *conn) openTx() *Tx {
+	c.mu.Lock()
+	tx := c.tx
+	c.mu.Unlock()
+
+	if tx == nil || tx.done() {
+		return nil
+	}
+	return tx
+}
+
 func (c *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
-	if c.queryFunc != nil {
-		return c.queryFunc(query, args)
+	return c.query(context.Background(), query, namedValues(args))
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.query(ctx, query, args)
+}
+
+func (c *conn) query(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	call := &Call{Op: OpQuery, ConnID: c.id, DSN: c.dsn, Query: query, Args: args}
+	c.intercept(call, func(call *Call) {
+		call.Rows, call.Err = c.runQuery(ctx, call.Query, call.Args)
+	})
+	return c.interceptRows(call.Rows), call.error(call.Rows == nil)
+}
+
+func (c *conn) runQuery(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.config.delay(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := c.passGate(ctx, c.queryGates, query); err != nil {
+		return nil, err
+	}
+
+	rows, err := c.dispatchQuery(query, args)
+	c.logQuery(c, query, args, false, err)
+	return c.trackRows(rows), err
+}
+
+func (c *conn) dispatchQuery(query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.attempt(query); err != nil {
+		return nil, err
 	}
-	if q, ok := d.conn.queries[getQueryHash(query)]; ok {
+	if err := c.checkNumInput(query, len(args)); err != nil {
+		return nil, err
+	}
+
+	if rows, ok, err := c.handleQuery(query, args); ok {
+		return rows, err
+	}
+	if rows, _, ok, err := c.runTable(query, args); ok {
+		return rows, err
+	}
+	if q := c.lookup(query, args); q != nil && (q.rows != nil || q.err != nil) {
+		c.hit(q.usage)
 		if rows, ok := q.rows.(*rows); ok {
 			return rows.clone(), q.err
 		}
 		return q.rows, q.err
 	}
-	return nil, errors.New("Query not stubbed: " + query)
+	if err := c.notStubbed(query, args, false); err != nil {
+		return nil, err
+	}
+	return &rows{}, nil
 }
 
 func (c *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
-	if c.execFunc != nil {
-		return c.execFunc(query, args)
+	return c.exec(context.Background(), query, namedValues(args))
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.exec(ctx, query, args)
+}
+
+func (c *conn) exec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	call := &Call{Op: OpExec, ConnID: c.id, DSN: c.dsn, Query: query, Args: args}
+	c.intercept(call, func(call *Call) {
+		call.Result, call.Err = c.runExec(ctx, call.Query, call.Args)
+	})
+	return call.Result, call.error(call.Result == nil)
+}
+
+func (c *conn) runExec(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.config.delay(ctx); err != nil {
+		return nil, err
 	}
 
-	if q, ok := d.conn.queries[getQueryHash(query)]; ok {
+	if err := c.passGate(ctx, c.execGates, query); err != nil {
+		return nil, err
+	}
+
+	res, err := c.dispatchExec(query, args)
+	c.logQuery(c, query, args, true, err)
+	if err == nil {
+		c.transition(query)
+	}
+
+	c.mu.Lock()
+	unsupported := c.noLastInsertId || c.config.noLastInsertId
+	c.mu.Unlock()
+	if res != nil && unsupported {
+		res = noLastInsertIdResult{res}
+	}
+	return res, err
+}
+
+func (c *conn) dispatchExec(query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.attempt(query); err != nil {
+		return nil, err
+	}
+	if err := c.checkNumInput(query, len(args)); err != nil {
+		return nil, err
+	}
+
+	if tx := c.openTx(); tx != nil {
+		if res, ok, err := tx.execSavepoint(query); ok {
+			return res, err
+		}
+	}
+
+	if res, ok, err := c.handleExec(query, args); ok {
+		return res, err
+	}
+	if _, res, ok, err := c.runTable(query, args); ok {
+		return res, err
+	}
+
+	if q := c.lookup(query, args); q != nil {
 		if q.result != nil {
-			return q.result, nil
+			c.hit(q.usage)
+			return q.result.forExec(), nil
 		} else if q.err != nil {
+			c.hit(q.usage)
 			return nil, q.err
 		}
 	}
 
-	return nil, errors.New("Exec call not stubbed: " + query)
+	if err := c.notStubbed(query, args, true); err != nil {
+		return nil, err
+	}
+	return NewResult(0, nil, 0, nil), nil
+}
+
+// Records where rows were handed out so CheckLeaks can report them if they're never closed.
+func (c *conn) trackRows(r driver.Rows) driver.Rows {
+	if rs, ok := r.(*rows); ok && rs != nil {
+		rs.track()
+
+		c.mu.Lock()
+		c.rows = append(c.rows, rs)
+		c.mu.Unlock()
+	}
+	return r
+}
+
+func (c *conn) trackStmt(s *stmt) {
+	s.track()
+
+	c.mu.Lock()
+	c.stmts = append(c.stmts, s)
+	c.mu.Unlock()
 }
@@ -0,0 +1,144 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestStubPingError(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	StubPingError(errors.New("database is down"))
+
+	if err := db.Ping(); err == nil || err.Error() != "database is down" {
+		t.Fatal("stubbed ping did not return expected error")
+	}
+}
+
+func TestStubResetSessionError(t *testing.T) {
+	defer Reset()
+
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+	StubResetSessionError(driver.ErrBadConn)
+
+	db, _ := sql.Open("testdb", "")
+
+	db.Exec("DELETE FROM users")
+	if _, err := db.Exec("DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ConnsOpened() != 2 || OpenConns() != 1 {
+		t.Fatalf("connection failing to reset should be discarded, opened %d with %d open", ConnsOpened(), OpenConns())
+	}
+}
+
+func TestInvalidateConns(t *testing.T) {
+	defer Reset()
+
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+
+	db.Exec("DELETE FROM users")
+	db.Exec("DELETE FROM users")
+	if ConnsOpened() != 1 {
+		t.Fatal("idle connection should be reused")
+	}
+
+	// database/sql checks validity when a connection is returned to the pool, so it's used once more
+	InvalidateConns()
+	db.Exec("DELETE FROM users")
+	db.Exec("DELETE FROM users")
+
+	if log := QueryLog(); log[2].ConnID != 1 || log[3].ConnID != 2 {
+		t.Fatal("invalid connection should not be returned to the pool")
+	}
+}
+
+func TestInvalidateConn(t *testing.T) {
+	defer Reset()
+
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+	db.Exec("DELETE FROM users")
+
+	InvalidateConn(2)
+	db.Exec("DELETE FROM users")
+
+	if ConnsOpened() != 1 {
+		t.Fatal("invalidating a different connection should not affect the idle one")
+	}
+}
+
+func TestStubBadConn(t *testing.T) {
+	defer Reset()
+
+	q := "select id from users"
+	StubQuery(q, RowsFromCSVString([]string{"id"}, "1"))
+	StubBadConn(q, 2)
+
+	db, _ := sql.Open("testdb", "")
+
+	rows, err := db.Query(q)
+	if err != nil {
+		t.Fatal("database/sql should retry bad connections", err)
+	}
+	rows.Close()
+
+	if n := Attempts(q); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestStubBadConnExhaustsRetries(t *testing.T) {
+	defer Reset()
+
+	q := "DELETE FROM users"
+	StubExec(q, NewResult(0, nil, 1, nil))
+	StubBadConn(q, 5)
+
+	db, _ := sql.Open("testdb", "")
+
+	// database/sql makes 3 attempts per call before giving up
+	if _, err := db.Exec(q); err != driver.ErrBadConn {
+		t.Fatal("expected driver.ErrBadConn once retries ran out, got", err)
+	}
+	if n := Attempts(q); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	if _, err := db.Exec(q); err != nil {
+		t.Fatal("expected the third attempt of the second exec to succeed", err)
+	}
+	if n := Attempts(q); n != 6 {
+		t.Fatalf("expected 6 attempts, got %d", n)
+	}
+}
+
+func TestStubBeginBadConn(t *testing.T) {
+	defer Reset()
+
+	StubBeginBadConn(1)
+
+	db, _ := sql.Open("testdb", "")
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal("database/sql should retry bad connections", err)
+	}
+	tx.Rollback()
+
+	if n := BeginAttempts(); n != 2 {
+		t.Fatalf("expected 2 attempts, got %d", n)
+	}
+}
@@ -0,0 +1,482 @@
+package testdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// A Connector holds a set of stubs and config, and hands out connections which share them. Use it with sql.OpenDB() to give each test or dependency its own independent stubs, the package level functions operate on the Connector behind the global driver.Conn.
+type Connector struct {
+	queries      map[string][]*query
+	queryFunc    func(query string, args []driver.NamedValue) (driver.Rows, error)
+	execFunc     func(query string, args []driver.NamedValue) (driver.Result, error)
+	beginFunc    func() (driver.Tx, error)
+	commitFunc   func() error
+	rollbackFunc func() error
+	pingFunc     func() error
+	resetFunc    func() error
+
+	// Run in order after queryFunc and execFunc, until one doesn't return ErrNotHandled
+	queryHandlers []queryHandler
+	execHandlers  []execHandler
+
+	queryFuncUsage *StubUsage
+	execFuncUsage  *StubUsage
+
+	// Savepoint errors copied onto every transaction started by Begin
+	savepointErrs map[SavepointOp]map[string]error
+
+	passThroughArgs map[reflect.Type]bool
+	argConverters   map[reflect.Type]func(interface{}) (driver.Value, error)
+	argConverter    driver.ValueConverter
+	config          connConfig
+
+	mu             sync.Mutex
+	lastConnID     int64
+	openConns      int
+	maxConns       int
+	failOpensAfter int
+	failOpenErr    error
+	conns          map[int64]*conn
+	badConns       map[string]int
+	attempts       map[string]int
+	beginBadConns  int
+	beginAttempts  int
+	queryGates     map[string]*Gate
+	execGates      map[string]*Gate
+	commitGate     *Gate
+	tables         map[string]*Table
+	sequences      []*Sequence
+	scenarios      []*Scenario
+	interceptors   []Interceptor
+
+	unstubbedPolicy UnstubbedPolicy
+	unstubbedTB     testing.TB
+	unstubbed       []*ErrNotStubbed
+
+	// Orders stubs and handlers by when they were registered
+	stubSeq        int64
+	checkUnused    bool
+	noLastInsertId bool
+	txs            []*Tx
+	rows           []*rows
+	stmts          []*stmt
+	log            []QueryLogEntry
+	// Closed when the next call is logged
+	logged chan struct{}
+}
+
+// Configures a Connector created by NewConnector().
+type Option func(*Connector)
+
+// Creates a Connector with no stubs, for use with sql.OpenDB().
+func NewConnector(options ...Option) *Connector {
+	c := &Connector{
+		config:          defaultConfig(),
+		failOpensAfter:  -1,
+		conns:           make(map[int64]*conn),
+		badConns:        make(map[string]int),
+		attempts:        make(map[string]int),
+		queryGates:      make(map[string]*Gate),
+		execGates:       make(map[string]*Gate),
+		tables:          make(map[string]*Table),
+		queries:         make(map[string][]*query),
+		passThroughArgs: make(map[reflect.Type]bool),
+		argConverters:   make(map[reflect.Type]func(interface{}) (driver.Value, error)),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Turns on placeholder counting for connections from the Connector, see EnablePlaceholderCounting().
+func WithPlaceholderCounting() Option {
+	return func(c *Connector) {
+		c.config.strict = true
+	}
+}
+
+// Sets the converter used for arguments, see SetArgConverter().
+func WithArgConverter(vc driver.ValueConverter) Option {
+	return func(c *Connector) {
+		c.argConverter = vc
+	}
+}
+
+// Implements driver.Connector, every connection returned shares the Connector's stubs.
+func (c *Connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.interceptOpen("", c.config)
+}
+
+// Implements driver.Connector.
+func (c *Connector) Driver() driver.Driver {
+	return d
+}
+
+// Creates a new connection with its own ID, unless SetMaxConns() or FailOpensAfter() say otherwise.
+func (c *Connector) open(dsn string, cfg connConfig) (*conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failOpensAfter >= 0 && c.lastConnID >= int64(c.failOpensAfter) {
+		return nil, c.failOpenErr
+	}
+	if c.maxConns > 0 && c.openConns >= c.maxConns {
+		return nil, fmt.Errorf("testdb: too many connections (max %d)", c.maxConns)
+	}
+
+	c.lastConnID++
+	c.openConns++
+	cn := &conn{Connector: c, id: c.lastConnID, dsn: dsn, config: cfg}
+	c.conns[cn.id] = cn
+	return cn, nil
+}
+
+// See the package level SetMaxConns().
+func (c *Connector) SetMaxConns(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxConns = n
+}
+
+// See the package level FailOpensAfter().
+func (c *Connector) FailOpensAfter(n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failOpensAfter = n
+	c.failOpenErr = err
+}
+
+// See the package level OpenConns().
+func (c *Connector) OpenConns() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.openConns
+}
+
+// See the package level ConnsOpened().
+func (c *Connector) ConnsOpened() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return int(c.lastConnID)
+}
+
+// See the package level SetPingFunc().
+func (c *Connector) SetPingFunc(f func() error) {
+	c.pingFunc = f
+}
+
+// See the package level StubPingError().
+func (c *Connector) StubPingError(err error) {
+	c.SetPingFunc(func() error {
+		return err
+	})
+}
+
+// See the package level SetResetSessionFunc().
+func (c *Connector) SetResetSessionFunc(f func() error) {
+	c.resetFunc = f
+}
+
+// See the package level StubResetSessionError().
+func (c *Connector) StubResetSessionError(err error) {
+	c.SetResetSessionFunc(func() error {
+		return err
+	})
+}
+
+// See the package level InvalidateConn().
+func (c *Connector) InvalidateConn(id int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if cn, ok := c.conns[id]; ok {
+		cn.invalid = true
+	}
+}
+
+// See the package level InvalidateConns().
+func (c *Connector) InvalidateConns() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, cn := range c.conns {
+		cn.invalid = true
+	}
+}
+
+// See the package level StubBadConn().
+func (c *Connector) StubBadConn(q string, n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.badConns[getQueryHash(q)] = n
+}
+
+// See the package level StubBeginBadConn().
+func (c *Connector) StubBeginBadConn(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.beginBadConns = n
+}
+
+// See the package level Attempts().
+func (c *Connector) Attempts(q string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.attempts[getQueryHash(q)]
+}
+
+// See the package level BeginAttempts().
+func (c *Connector) BeginAttempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.beginAttempts
+}
+
+// Counts an attempt at running q, and returns driver.ErrBadConn while StubBadConn() says it should fail.
+func (c *Connector) attempt(q string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := getQueryHash(q)
+	c.attempts[hash]++
+	if c.badConns[hash] > 0 {
+		c.badConns[hash]--
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *Connector) attemptBegin() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.beginAttempts++
+	if c.beginBadConns > 0 {
+		c.beginBadConns--
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// See the package level GateQuery().
+func (c *Connector) GateQuery(q string) *Gate {
+	return c.gate(c.queryGates, q)
+}
+
+// See the package level GateExec().
+func (c *Connector) GateExec(q string) *Gate {
+	return c.gate(c.execGates, q)
+}
+
+// See the package level GateCommit().
+func (c *Connector) GateCommit() *Gate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.commitGate == nil {
+		c.commitGate = newGate()
+	}
+	return c.commitGate
+}
+
+func (c *Connector) gate(gates map[string]*Gate, q string) *Gate {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hash := getQueryHash(q)
+	if gates[hash] == nil {
+		gates[hash] = newGate()
+	}
+	return gates[hash]
+}
+
+// Blocks until the gate for q lets the call through, if there is one.
+func (c *Connector) passGate(ctx context.Context, gates map[string]*Gate, q string) error {
+	c.mu.Lock()
+	g := gates[getQueryHash(q)]
+	c.mu.Unlock()
+
+	if g == nil {
+		return nil
+	}
+	return g.pass(ctx)
+}
+
+// See the package level SetQueryFunc().
+func (c *Connector) SetQueryFunc(f func(query string) (result driver.Rows, err error)) {
+	c.SetQueryWithArgsFunc(func(query string, args []driver.Value) (result driver.Rows, err error) {
+		return f(query)
+	})
+}
+
+// See the package level SetQueryWithArgsFunc().
+func (c *Connector) SetQueryWithArgsFunc(f func(query string, args []driver.Value) (result driver.Rows, err error)) {
+	c.SetQueryWithNamedArgsFunc(func(query string, args []driver.NamedValue) (driver.Rows, error) {
+		return f(query, values(args))
+	})
+}
+
+// See the package level SetQueryWithNamedArgsFunc().
+func (c *Connector) SetQueryWithNamedArgsFunc(f func(query string, args []driver.NamedValue) (result driver.Rows, err error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queryFunc = f
+	c.queryFuncUsage = c.register(newHandlerUsage("query func"))
+}
+
+// See the package level StubQuery().
+func (c *Connector) StubQuery(q string, rows driver.Rows) {
+	c.stub(q, &query{rows: rows})
+}
+
+// See the package level StubQueryWithArgs().
+func (c *Connector) StubQueryWithArgs(q string, rows driver.Rows, args ...interface{}) {
+	c.stub(q, &query{rows: rows, args: expectedArgs(args)})
+}
+
+// See the package level StubQueryError().
+func (c *Connector) StubQueryError(q string, err error) {
+	c.stub(q, &query{err: err})
+}
+
+// See the package level StubQueryErrorWithArgs().
+func (c *Connector) StubQueryErrorWithArgs(q string, err error, args ...interface{}) {
+	c.stub(q, &query{err: err, args: expectedArgs(args)})
+}
+
+// See the package level SetExecFunc().
+func (c *Connector) SetExecFunc(f func(query string) (driver.Result, error)) {
+	c.SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
+		return f(query)
+	})
+}
+
+// See the package level SetExecWithArgsFunc().
+func (c *Connector) SetExecWithArgsFunc(f func(query string, args []driver.Value) (driver.Result, error)) {
+	c.SetExecWithNamedArgsFunc(func(query string, args []driver.NamedValue) (driver.Result, error) {
+		return f(query, values(args))
+	})
+}
+
+// See the package level SetExecWithNamedArgsFunc().
+func (c *Connector) SetExecWithNamedArgsFunc(f func(query string, args []driver.NamedValue) (driver.Result, error)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.execFunc = f
+	c.execFuncUsage = c.register(newHandlerUsage("exec func"))
+}
+
+// See the package level StubExec().
+func (c *Connector) StubExec(q string, r *Result) {
+	c.stub(q, &query{result: r})
+}
+
+// See the package level StubExecWithArgs().
+func (c *Connector) StubExecWithArgs(q string, r *Result, args ...interface{}) {
+	c.stub(q, &query{result: r, args: expectedArgs(args)})
+}
+
+// See the package level StubExecError().
+func (c *Connector) StubExecError(q string, err error) {
+	c.StubQueryError(q, err)
+}
+
+// See the package level StubExecErrorWithArgs().
+func (c *Connector) StubExecErrorWithArgs(q string, err error, args ...interface{}) {
+	c.StubQueryErrorWithArgs(q, err, args...)
+}
+
+// See the package level SetBeginFunc().
+func (c *Connector) SetBeginFunc(f func() (driver.Tx, error)) {
+	c.beginFunc = f
+}
+
+// See the package level StubBegin().
+func (c *Connector) StubBegin(tx driver.Tx, err error) {
+	c.SetBeginFunc(func() (driver.Tx, error) {
+		return tx, err
+	})
+}
+
+// See the package level SetCommitFunc().
+func (c *Connector) SetCommitFunc(f func() error) {
+	c.commitFunc = f
+}
+
+// See the package level StubCommitError().
+func (c *Connector) StubCommitError(err error) {
+	c.SetCommitFunc(func() error {
+		return err
+	})
+}
+
+// See the package level SetRollbackFunc().
+func (c *Connector) SetRollbackFunc(f func() error) {
+	c.rollbackFunc = f
+}
+
+// See the package level StubRollbackError().
+func (c *Connector) StubRollbackError(err error) {
+	c.SetRollbackFunc(func() error {
+		return err
+	})
+}
+
+// See the package level StubSavepointError().
+func (c *Connector) StubSavepointError(name string, err error) {
+	c.stubSavepointError(SavepointCreate, name, err)
+}
+
+// See the package level StubReleaseSavepointError().
+func (c *Connector) StubReleaseSavepointError(name string, err error) {
+	c.stubSavepointError(SavepointRelease, name, err)
+}
+
+// See the package level StubRollbackToSavepointError().
+func (c *Connector) StubRollbackToSavepointError(name string, err error) {
+	c.stubSavepointError(SavepointRollback, name, err)
+}
+
+func (c *Connector) stubSavepointError(op SavepointOp, name string, err error) {
+	if c.savepointErrs == nil {
+		c.savepointErrs = make(map[SavepointOp]map[string]error)
+	}
+	if c.savepointErrs[op] == nil {
+		c.savepointErrs[op] = make(map[string]error)
+	}
+	c.savepointErrs[op][name] = err
+}
+
+// See the package level LastTx().
+func (c *Connector) LastTx() *Tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.txs) == 0 {
+		return nil
+	}
+	return c.txs[len(c.txs)-1]
+}
+
+// See the package level PassThroughArgs().
+func (c *Connector) PassThroughArgs(examples ...interface{}) {
+	for _, e := range examples {
+		c.passThroughArgs[reflect.TypeOf(e)] = true
+	}
+}
+
+// See the package level RegisterArgConverter().
+func (c *Connector) RegisterArgConverter(example interface{}, f func(v interface{}) (driver.Value, error)) {
+	c.argConverters[reflect.TypeOf(example)] = f
+}
+
+// See the package level SetArgConverter().
+func (c *Connector) SetArgConverter(vc driver.ValueConverter) {
+	c.argConverter = vc
+}
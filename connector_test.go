@@ -0,0 +1,181 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestNewConnector(t *testing.T) {
+	defer Reset()
+
+	c := NewConnector()
+	c.StubQuery("SELECT name FROM users", RowsFromCSVString([]string{"name"}, "tim"))
+
+	db := sql.OpenDB(c)
+
+	var name string
+	if err := db.QueryRow("SELECT name FROM users").Scan(&name); err != nil || name != "tim" {
+		t.Fatalf("connector stub not used: %v", err)
+	}
+
+	global, _ := sql.Open("testdb", "")
+	if err := global.QueryRow("SELECT name FROM users").Scan(&name); err == nil {
+		t.Fatal("connector stubs should not leak into the global conn")
+	}
+
+	if len(c.QueryLog()) != 1 || len(QueryLog()) != 1 {
+		t.Fatal("queries should be logged on the connector that served them")
+	}
+}
+
+func TestNewConnectorOptions(t *testing.T) {
+	c := NewConnector(WithPlaceholderCounting())
+	c.StubExec("DELETE FROM users WHERE id = ?", NewResult(0, nil, 1, nil))
+
+	db := sql.OpenDB(c)
+
+	if _, err := db.Exec("DELETE FROM users WHERE id = ?"); err == nil {
+		t.Fatal("placeholder counting option was not applied")
+	}
+}
+
+func TestRegisterConnector(t *testing.T) {
+	defer Reset()
+
+	replica := NewConnector()
+	replica.StubQuery("SELECT name FROM users", RowsFromCSVString([]string{"name"}, "joe"))
+	RegisterConnector("replica", replica)
+
+	StubQuery("SELECT name FROM users", RowsFromCSVString([]string{"name"}, "tim"))
+
+	primaryDB, _ := sql.Open("testdb", "primary")
+	replicaDB, _ := sql.Open("testdb", "replica")
+
+	var primaryName, replicaName string
+	primaryDB.QueryRow("SELECT name FROM users").Scan(&primaryName)
+	replicaDB.QueryRow("SELECT name FROM users").Scan(&replicaName)
+
+	if primaryName != "tim" || replicaName != "joe" {
+		t.Fatalf("DSN was not routed to the registered connector: %s, %s", primaryName, replicaName)
+	}
+}
+
+func TestOpenDistinctConns(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT 1", RowsFromCSVString([]string{"one"}, "1"))
+
+	db, _ := sql.Open("testdb", "")
+
+	// Holding the first rows open forces the pool to open a second connection
+	first, _ := db.Query("SELECT 1")
+	second, _ := db.Query("SELECT 1")
+	first.Close()
+	second.Close()
+
+	if ConnsOpened() != 2 || OpenConns() != 2 {
+		t.Fatalf("expected 2 connections, opened %d with %d open", ConnsOpened(), OpenConns())
+	}
+
+	log := QueryLog()
+	if log[0].ConnID != 1 || log[1].ConnID != 2 {
+		t.Fatal("query log should record which connection served each query")
+	}
+
+	db.Close()
+
+	if OpenConns() != 0 {
+		t.Fatal("closing the db should close every connection")
+	}
+}
+
+func TestSetMaxConns(t *testing.T) {
+	defer Reset()
+
+	SetMaxConns(1)
+	StubQuery("SELECT 1", RowsFromCSVString([]string{"one"}, "1"))
+
+	db, _ := sql.Open("testdb", "")
+
+	rows, err := db.Query("SELECT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	if _, err := db.Query("SELECT 1"); err == nil || err.Error() != "testdb: too many connections (max 1)" {
+		t.Fatalf("opening more connections than the max should fail, got %v", err)
+	}
+}
+
+func TestFailOpensAfter(t *testing.T) {
+	defer Reset()
+
+	FailOpensAfter(0, errors.New("connection refused"))
+
+	db, _ := sql.Open("testdb", "")
+
+	if err := db.Ping(); err == nil || err.Error() != "connection refused" {
+		t.Fatalf("open should have failed, got %v", err)
+	}
+}
+
+func TestBadConnEvictsConn(t *testing.T) {
+	defer Reset()
+
+	bad := true
+	SetExecFunc(func(query string) (driver.Result, error) {
+		if bad {
+			bad = false
+			return nil, driver.ErrBadConn
+		}
+		return NewResult(0, nil, 1, nil), nil
+	})
+
+	db, _ := sql.Open("testdb", "")
+
+	if _, err := db.Exec("DELETE FROM users"); err != nil {
+		t.Fatal(err)
+	}
+
+	if ConnsOpened() != 2 || OpenConns() != 1 {
+		t.Fatalf("bad connection should be replaced, opened %d with %d open", ConnsOpened(), OpenConns())
+	}
+}
+
+func TestDSN(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT name FROM users", RowsFromCSVString([]string{"name"}, "primary"))
+	DSN("replica").StubQuery("SELECT name FROM users", RowsFromCSVString([]string{"name"}, "replica"))
+	StubExec("UPDATE users SET name = 'tim'", NewResult(0, nil, 1, nil))
+
+	primary, _ := sql.Open("testdb", "primary")
+	replica, _ := sql.Open("testdb", "replica")
+
+	var name string
+	replica.QueryRow("SELECT name FROM users").Scan(&name)
+	if name != "replica" {
+		t.Fatalf("replica DSN should use its own stubs, got %s", name)
+	}
+
+	primary.Exec("UPDATE users SET name = 'tim'")
+	primary.QueryRow("SELECT name FROM users").Scan(&name)
+	if name != "primary" {
+		t.Fatalf("primary DSN should use the global stubs, got %s", name)
+	}
+
+	if _, err := replica.Exec("UPDATE users SET name = 'tim'"); err == nil {
+		t.Fatal("global stubs should not be visible to the replica DSN")
+	}
+
+	if dsns := ServedBy("select name from users"); len(dsns) != 2 || dsns[0] != "replica" || dsns[1] != "primary" {
+		t.Fatalf("unexpected DSNs serving query %v", dsns)
+	}
+
+	if log := DSN("replica").QueryLog(); log[0].DSN != "replica" {
+		t.Fatal("query log should record the DSN of the connection")
+	}
+}
@@ -0,0 +1,183 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"strings"
+)
+
+// Which database an Error imitates, it decides the codes and messages the builders use and how Error() is formatted.
+type ErrorProfile int
+
+const (
+	// Postgres messages and SQLSTATE codes, with the MySQL error number also set so either can be switched on
+	ProfileGeneric ErrorProfile = iota
+	// Formatted like pgx, "ERROR: message (SQLSTATE code)"
+	ProfilePostgres
+	// Formatted like go-sql-driver/mysql, "Error number (code): message"
+	ProfileMySQL
+)
+
+// An error returned by the database, with the fields drivers commonly expose. Use it with StubQueryError() or StubExecError() to exercise code that inspects database errors.
+type Error struct {
+	// SQLSTATE code, such as "23505" for a unique violation
+	Code string
+	// Vendor error number, such as MySQL's 1062 for a duplicate entry
+	Number     int
+	Message    string
+	Detail     string
+	Constraint string
+	Table      string
+	Column     string
+	Profile    ErrorProfile
+
+	// Wrapped error, driver.ErrBadConn for connection failures so database/sql retries them
+	err error
+}
+
+func (e *Error) Error() string {
+	switch e.Profile {
+	case ProfilePostgres:
+		if e.Detail != "" {
+			return fmt.Sprintf("ERROR: %s (SQLSTATE %s)\nDETAIL: %s", e.Message, e.Code, e.Detail)
+		}
+		return fmt.Sprintf("ERROR: %s (SQLSTATE %s)", e.Message, e.Code)
+	case ProfileMySQL:
+		return fmt.Sprintf("Error %d (%s): %s", e.Number, e.Code, e.Message)
+	}
+	return fmt.Sprintf("testdb: %s (SQLSTATE %s)", e.Message, e.Code)
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// Sets the detail, such as Postgres' "Key (email)=(tim@example.com) already exists.", and returns the error.
+func (e *Error) WithDetail(detail string) *Error {
+	e.Detail = detail
+	return e
+}
+
+// The code, number and message of an error in one database.
+type errorSpec struct {
+	code    string
+	number  int
+	message string
+}
+
+// Fills in the code, number and message from the spec matching the profile.
+func (p ErrorProfile) build(e *Error, postgres, mysql errorSpec) *Error {
+	spec := postgres
+	if p == ProfileMySQL {
+		spec = mysql
+	}
+	e.Code, e.Number, e.Message = spec.code, spec.number, spec.message
+	if p == ProfileGeneric {
+		e.Number = mysql.number
+	}
+	e.Profile = p
+	return e
+}
+
+// Returns a unique constraint violation, SQLSTATE 23505 or MySQL error 1062. When the columns of the key are supplied they're set as Column and named in the detail, as Postgres does.
+func (p ErrorProfile) UniqueViolation(table, constraint string, columns ...string) *Error {
+	e := &Error{Table: table, Constraint: constraint}
+	if len(columns) > 0 {
+		e.Column = strings.Join(columns, ", ")
+		if p != ProfileMySQL {
+			e.Detail = fmt.Sprintf("Key (%s) already exists.", e.Column)
+		}
+	}
+	return p.build(e,
+		errorSpec{"23505", 0, fmt.Sprintf("duplicate key value violates unique constraint %q", constraint)},
+		errorSpec{"23000", 1062, fmt.Sprintf("Duplicate entry for key '%s.%s'", table, constraint)})
+}
+
+// Returns a foreign key violation, SQLSTATE 23503 or MySQL error 1452.
+func (p ErrorProfile) ForeignKeyViolation(table, constraint string) *Error {
+	return p.build(&Error{Table: table, Constraint: constraint},
+		errorSpec{"23503", 0, fmt.Sprintf("insert or update on table %q violates foreign key constraint %q", table, constraint)},
+		errorSpec{"23000", 1452, fmt.Sprintf("Cannot add or update a child row: a foreign key constraint fails (`%s`, CONSTRAINT `%s`)", table, constraint)})
+}
+
+// Returns a not-null violation, SQLSTATE 23502 or MySQL error 1048.
+func (p ErrorProfile) NotNullViolation(table, column string) *Error {
+	return p.build(&Error{Table: table, Column: column},
+		errorSpec{"23502", 0, fmt.Sprintf("null value in column %q of relation %q violates not-null constraint", column, table)},
+		errorSpec{"23000", 1048, fmt.Sprintf("Column '%s' cannot be null", column)})
+}
+
+// Returns a check constraint violation, SQLSTATE 23514 or MySQL error 3819.
+func (p ErrorProfile) CheckViolation(table, constraint string) *Error {
+	return p.build(&Error{Table: table, Constraint: constraint},
+		errorSpec{"23514", 0, fmt.Sprintf("new row for relation %q violates check constraint %q", table, constraint)},
+		errorSpec{"HY000", 3819, fmt.Sprintf("Check constraint '%s' is violated.", constraint)})
+}
+
+// Returns a deadlock, SQLSTATE 40P01 or MySQL error 1213.
+func (p ErrorProfile) Deadlock() *Error {
+	return p.build(&Error{},
+		errorSpec{"40P01", 0, "deadlock detected"},
+		errorSpec{"40001", 1213, "Deadlock found when trying to get lock; try restarting transaction"})
+}
+
+// Returns a serialization failure, SQLSTATE 40001. MySQL reports these as deadlocks, so the MySQL profile returns error 1213.
+func (p ErrorProfile) SerializationFailure() *Error {
+	return p.build(&Error{},
+		errorSpec{"40001", 0, "could not serialize access due to concurrent update"},
+		errorSpec{"40001", 1213, "Deadlock found when trying to get lock; try restarting transaction"})
+}
+
+// Returns a statement timeout, SQLSTATE 57014 or MySQL error 3024.
+func (p ErrorProfile) Timeout() *Error {
+	return p.build(&Error{},
+		errorSpec{"57014", 0, "canceling statement due to statement timeout"},
+		errorSpec{"HY000", 3024, "Query execution was interrupted, maximum statement execution time exceeded"})
+}
+
+// Returns a lost connection, SQLSTATE 08006 or MySQL error 2013. It wraps driver.ErrBadConn, so database/sql retries it on a new connection.
+func (p ErrorProfile) ConnectionFailure() *Error {
+	return p.build(&Error{err: driver.ErrBadConn},
+		errorSpec{"08006", 0, "server closed the connection unexpectedly"},
+		errorSpec{"HY000", 2013, "Lost connection to MySQL server during query"})
+}
+
+// Returns a unique constraint violation using the generic profile, see ErrorProfile.UniqueViolation().
+func UniqueViolation(table, constraint string, columns ...string) *Error {
+	return ProfileGeneric.UniqueViolation(table, constraint, columns...)
+}
+
+// Returns a foreign key violation using the generic profile, see ErrorProfile.ForeignKeyViolation().
+func ForeignKeyViolation(table, constraint string) *Error {
+	return ProfileGeneric.ForeignKeyViolation(table, constraint)
+}
+
+// Returns a not-null violation using the generic profile, see ErrorProfile.NotNullViolation().
+func NotNullViolation(table, column string) *Error {
+	return ProfileGeneric.NotNullViolation(table, column)
+}
+
+// Returns a check constraint violation using the generic profile, see ErrorProfile.CheckViolation().
+func CheckViolation(table, constraint string) *Error {
+	return ProfileGeneric.CheckViolation(table, constraint)
+}
+
+// Returns a deadlock using the generic profile, see ErrorProfile.Deadlock().
+func Deadlock() *Error {
+	return ProfileGeneric.Deadlock()
+}
+
+// Returns a serialization failure using the generic profile, see ErrorProfile.SerializationFailure().
+func SerializationFailure() *Error {
+	return ProfileGeneric.SerializationFailure()
+}
+
+// Returns a statement timeout using the generic profile, see ErrorProfile.Timeout().
+func Timeout() *Error {
+	return ProfileGeneric.Timeout()
+}
+
+// Returns a lost connection using the generic profile, see ErrorProfile.ConnectionFailure().
+func ConnectionFailure() *Error {
+	return ProfileGeneric.ConnectionFailure()
+}
@@ -0,0 +1,97 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"testing"
+)
+
+func TestStubStructuredError(t *testing.T) {
+	defer Reset()
+
+	q := "INSERT INTO users (email) VALUES (?)"
+	StubExecError(q, UniqueViolation("users", "users_email_key"))
+
+	db, _ := sql.Open("testdb", "")
+
+	_, err := db.Exec(q, "tim@example.com")
+
+	var dbErr *Error
+	if !errors.As(err, &dbErr) {
+		t.Fatal("expected a *testdb.Error, got", err)
+	}
+	if dbErr.Code != "23505" || dbErr.Number != 1062 || dbErr.Constraint != "users_email_key" || dbErr.Table != "users" {
+		t.Fatalf("unexpected error fields %+v", dbErr)
+	}
+	if err.Error() != `testdb: duplicate key value violates unique constraint "users_email_key" (SQLSTATE 23505)` {
+		t.Fatal("unexpected error message", err)
+	}
+}
+
+func TestErrorProfiles(t *testing.T) {
+	pg := ProfilePostgres.NotNullViolation("users", "email")
+	if pg.Error() != `ERROR: null value in column "email" of relation "users" violates not-null constraint (SQLSTATE 23502)` {
+		t.Fatal("unexpected postgres message", pg)
+	}
+	if pg.Number != 0 {
+		t.Fatal("postgres errors should not have a vendor number")
+	}
+
+	my := ProfileMySQL.NotNullViolation("users", "email")
+	if my.Error() != "Error 1048 (23000): Column 'email' cannot be null" {
+		t.Fatal("unexpected mysql message", my)
+	}
+	if my.Column != "email" {
+		t.Fatal("expected the column to be set")
+	}
+
+	if ProfileMySQL.SerializationFailure().Number != 1213 || ProfilePostgres.SerializationFailure().Code != "40001" {
+		t.Fatal("unexpected serialization failure codes")
+	}
+}
+
+func TestErrorDetail(t *testing.T) {
+	pg := ProfilePostgres.UniqueViolation("users", "users_email_key", "email")
+	if pg.Column != "email" || pg.Detail != "Key (email) already exists." {
+		t.Fatalf("unexpected error fields %+v", pg)
+	}
+	if pg.Error() != "ERROR: duplicate key value violates unique constraint \"users_email_key\" (SQLSTATE 23505)\nDETAIL: Key (email) already exists." {
+		t.Fatal("unexpected postgres message", pg)
+	}
+
+	if my := ProfileMySQL.UniqueViolation("users", "users_email_key", "email"); my.Detail != "" || my.Column != "email" {
+		t.Fatalf("unexpected error fields %+v", my)
+	}
+
+	detail := "Failing row contains (1, null)."
+	if err := NotNullViolation("users", "email").WithDetail(detail); err.Detail != detail {
+		t.Fatal("expected the detail to be set")
+	}
+}
+
+func TestConnectionFailureIsBadConn(t *testing.T) {
+	defer Reset()
+
+	q := "SELECT id FROM users"
+	calls := 0
+	SetQueryFunc(func(query string) (driver.Rows, error) {
+		calls++
+		if calls == 1 {
+			return nil, ProfilePostgres.ConnectionFailure()
+		}
+		return RowsFromCSVString([]string{"id"}, "1"), nil
+	})
+
+	if !errors.Is(ConnectionFailure(), driver.ErrBadConn) {
+		t.Fatal("connection failures should wrap driver.ErrBadConn")
+	}
+
+	db, _ := sql.Open("testdb", "")
+
+	rows, err := db.Query(q)
+	if err != nil {
+		t.Fatal("database/sql should retry connection failures", err)
+	}
+	rows.Close()
+}
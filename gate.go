@@ -0,0 +1,101 @@
+package testdb
+
+import (
+	"context"
+	"sync"
+)
+
+// A Gate blocks the calls it's attached to until the test releases them, or until the caller's context is done. Use it to stop calls mid-flight and pick the order they finish in.
+type Gate struct {
+	mu      sync.Mutex
+	open    bool
+	permits int
+	parked  int
+	// Closed and replaced whenever the gate changes, to wake everyone waiting on it
+	changed chan struct{}
+}
+
+func newGate() *Gate {
+	return &Gate{changed: make(chan struct{})}
+}
+
+// Must be called with mu held.
+func (g *Gate) broadcast() {
+	close(g.changed)
+	g.changed = make(chan struct{})
+}
+
+// Opens the gate for good, every parked call and every later call goes through.
+func (g *Gate) Release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.open = true
+	g.broadcast()
+}
+
+// Lets a single call through. If no call is parked the next call to reach the gate goes straight through.
+func (g *Gate) ReleaseOne() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.permits++
+	g.broadcast()
+}
+
+// Returns the number of calls currently blocked at the gate.
+func (g *Gate) Parked() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.parked
+}
+
+// Blocks until at least n calls are parked at the gate, or returns the context's error if it's done first.
+func (g *Gate) Wait(ctx context.Context, n int) error {
+	g.mu.Lock()
+	for g.parked < n {
+		changed := g.changed
+		g.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		g.mu.Lock()
+	}
+	g.mu.Unlock()
+	return nil
+}
+
+// Blocks the caller until the gate lets it through, or returns the context's error if it's done first.
+func (g *Gate) pass(ctx context.Context) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.parked++
+	g.broadcast()
+	defer func() {
+		g.parked--
+		g.broadcast()
+	}()
+
+	for !g.open && g.permits == 0 {
+		changed := g.changed
+		g.mu.Unlock()
+
+		var err error
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		g.mu.Lock()
+
+		if err != nil {
+			return err
+		}
+	}
+	if !g.open {
+		g.permits--
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestGateExec(t *testing.T) {
+	defer Reset()
+
+	q := "INSERT INTO users (email) VALUES (?)"
+	StubExec(q, NewResult(1, nil, 1, nil))
+	gate := GateExec(q)
+
+	db, _ := sql.Open("testdb", "")
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := db.Exec(q, "tim@example.com")
+			done <- err
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := gate.Wait(ctx, 2); err != nil {
+		t.Fatal("expected both execs to park at the gate", err)
+	}
+	if n := len(QueryLog()); n != 0 {
+		t.Fatalf("parked execs should not have run, got %d", n)
+	}
+
+	gate.ReleaseOne()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if gate.Parked() != 1 {
+		t.Fatal("expected one exec to still be parked")
+	}
+
+	gate.Release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(q, "joe@example.com"); err != nil {
+		t.Fatal("a released gate should let later calls through", err)
+	}
+}
+
+func TestGateQueryContextCancelled(t *testing.T) {
+	defer Reset()
+
+	q := "SELECT id FROM users"
+	StubQuery(q, RowsFromCSVString([]string{"id"}, "1"))
+	GateQuery(q)
+
+	db, _ := sql.Open("testdb", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := db.QueryContext(ctx, q); err != context.DeadlineExceeded {
+		t.Fatal("expected the query to give up when its context is done, got", err)
+	}
+}
+
+func TestGateCommit(t *testing.T) {
+	defer Reset()
+
+	gate := GateCommit()
+
+	db, _ := sql.Open("testdb", "")
+	tx, _ := db.Begin()
+
+	done := make(chan error)
+	go func() {
+		done <- tx.Commit()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := gate.Wait(ctx, 1); err != nil {
+		t.Fatal("expected the commit to park at the gate", err)
+	}
+	if LastTx().State() != TxOpen {
+		t.Fatal("a parked commit should leave the transaction open")
+	}
+
+	gate.Release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if LastTx().State() != TxCommitted {
+		t.Fatal("expected the transaction to be committed")
+	}
+}
@@ -0,0 +1,96 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"errors"
+)
+
+// Returned by a query or exec handler, or by the function passed to SetQueryWithArgsFunc() and friends, to pass the call on to the next handler and then the stubs.
+var ErrNotHandled = errors.New("testdb: not handled")
+
+// A handler for query calls, see AddQueryHandler().
+type QueryHandler func(query string, args []driver.NamedValue) (driver.Rows, error)
+
+// A handler for exec calls, see AddExecHandler().
+type ExecHandler func(query string, args []driver.NamedValue) (driver.Result, error)
+
+// Adds a handler for db.Query calls. Handlers run in the order they were added, after the function set with SetQueryFunc() and before the stubs, and the first one not returning ErrNotHandled decides the result.
+func AddQueryHandler(h QueryHandler) {
+	d.conn.AddQueryHandler(h)
+}
+
+// Adds a handler for db.Exec calls. Handlers run in the order they were added, after the function set with SetExecFunc() and before the stubs, and the first one not returning ErrNotHandled decides the result.
+func AddExecHandler(h ExecHandler) {
+	d.conn.AddExecHandler(h)
+}
+
+// See the package level AddQueryHandler().
+func (c *Connector) AddQueryHandler(h QueryHandler) {
+	usage := newHandlerUsage("query handler")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queryHandlers = append(c.queryHandlers, queryHandler{h, c.register(usage)})
+}
+
+// See the package level AddExecHandler().
+func (c *Connector) AddExecHandler(h ExecHandler) {
+	usage := newHandlerUsage("exec handler")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.execHandlers = append(c.execHandlers, execHandler{h, c.register(usage)})
+}
+
+type queryHandler struct {
+	handle QueryHandler
+	usage  *StubUsage
+}
+
+type execHandler struct {
+	handle ExecHandler
+	usage  *StubUsage
+}
+
+// Runs the query function and handlers in order, ok is false if none of them handled the call.
+func (c *Connector) handleQuery(query string, args []driver.NamedValue) (rows driver.Rows, ok bool, err error) {
+	c.mu.Lock()
+	handlers := append([]queryHandler(nil), c.queryHandlers...)
+	if c.queryFunc != nil {
+		handlers = append([]queryHandler{{c.queryFunc, c.queryFuncUsage}}, handlers...)
+	}
+	c.mu.Unlock()
+
+	for _, h := range handlers {
+		if rows, err = h.handle(query, args); !errors.Is(err, ErrNotHandled) {
+			c.hit(h.usage)
+			return rows, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// Runs the exec function and handlers in order, ok is false if none of them handled the call.
+func (c *Connector) handleExec(query string, args []driver.NamedValue) (res driver.Result, ok bool, err error) {
+	c.mu.Lock()
+	handlers := append([]execHandler(nil), c.execHandlers...)
+	if c.execFunc != nil {
+		handlers = append([]execHandler{{c.execFunc, c.execFuncUsage}}, handlers...)
+	}
+	c.mu.Unlock()
+
+	for _, h := range handlers {
+		if res, err = h.handle(query, args); !errors.Is(err, ErrNotHandled) {
+			c.hit(h.usage)
+			return res, true, err
+		}
+	}
+	return nil, false, nil
+}
+
+// Reports whether any query or exec handler is set, in which case every statement can be prepared.
+func (c *Connector) hasHandlers() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.queryFunc != nil || c.execFunc != nil || len(c.queryHandlers) > 0 || len(c.execHandlers) > 0
+}
@@ -0,0 +1,72 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestQueryFuncFallsThroughToStubs(t *testing.T) {
+	defer Reset()
+
+	SetQueryWithArgsFunc(func(query string, args []driver.Value) (driver.Rows, error) {
+		if strings.Contains(query, "dynamic") {
+			return RowsFromCSVString([]string{"name"}, "dynamic"), nil
+		}
+		return nil, ErrNotHandled
+	})
+	StubQuery("SELECT name FROM static", RowsFromCSVString([]string{"name"}, "static"))
+
+	db, _ := sql.Open("testdb", "")
+
+	var name string
+	db.QueryRow("SELECT name FROM dynamic").Scan(&name)
+	if name != "dynamic" {
+		t.Fatal("expected the query func to handle the query, got", name)
+	}
+
+	db.QueryRow("SELECT name FROM static").Scan(&name)
+	if name != "static" {
+		t.Fatal("expected the stub to handle the query, got", name)
+	}
+
+	if _, err := db.Query("SELECT name FROM missing"); !strings.HasPrefix(fmt.Sprint(err), "Query not stubbed: SELECT name FROM missing") {
+		t.Fatal("expected the query to be reported as not stubbed, got", err)
+	}
+}
+
+func TestHandlerChain(t *testing.T) {
+	defer Reset()
+
+	var calls []string
+	AddExecHandler(func(query string, args []driver.NamedValue) (driver.Result, error) {
+		calls = append(calls, "first")
+		return nil, ErrNotHandled
+	})
+	AddExecHandler(func(query string, args []driver.NamedValue) (driver.Result, error) {
+		calls = append(calls, "second")
+		if args[0].Value == int64(1) {
+			return NewResult(0, nil, 1, nil), nil
+		}
+		return nil, ErrNotHandled
+	})
+	StubExec("DELETE FROM users WHERE id = ?", NewResult(0, nil, 5, nil))
+
+	db, _ := sql.Open("testdb", "")
+
+	res, _ := db.Exec("DELETE FROM users WHERE id = ?", 1)
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatal("expected the second handler to handle the exec")
+	}
+
+	res, _ = db.Exec("DELETE FROM users WHERE id = ?", 2)
+	if n, _ := res.RowsAffected(); n != 5 {
+		t.Fatal("expected the stub to handle the exec")
+	}
+
+	if strings.Join(calls, ",") != "first,second,first,second" {
+		t.Fatal("expected handlers to run in order, got", calls)
+	}
+}
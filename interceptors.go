@@ -0,0 +1,200 @@
+package testdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+)
+
+// A driver operation seen by interceptors.
+type Op int
+
+const (
+	OpOpen Op = iota
+	OpPrepare
+	OpQuery
+	OpExec
+	OpBegin
+	OpCommit
+	OpRollback
+	OpRowsNext
+	OpRowsClose
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpOpen:
+		return "open"
+	case OpPrepare:
+		return "prepare"
+	case OpQuery:
+		return "query"
+	case OpExec:
+		return "exec"
+	case OpBegin:
+		return "begin"
+	case OpCommit:
+		return "commit"
+	case OpRollback:
+		return "rollback"
+	case OpRowsNext:
+		return "rows next"
+	case OpRowsClose:
+		return "rows close"
+	}
+	return "unknown"
+}
+
+// A single driver call as seen by an Interceptor. Before hooks can change the DSN, query and arguments, after hooks can change the results and error. Changing the DSN of an open only changes the name the connection reports, the Connector and options are picked before the hooks run, and the connection an open returns can't be replaced.
+type Call struct {
+	Op Op
+	// ID of the connection making the call, 0 for an open that failed
+	ConnID int64
+	DSN    string
+	// Set for prepare, query and exec calls
+	Query string
+	Args  []driver.NamedValue
+
+	// Set after query calls
+	Rows driver.Rows
+	// Set after exec calls
+	Result driver.Result
+	// Set after prepare calls
+	Stmt driver.Stmt
+	// Set after begin calls
+	Tx driver.Tx
+	// Set after rows next calls, the values of the row read
+	Row []driver.Value
+	Err error
+}
+
+// Hooks run around every driver call, see AddInterceptor().
+type Interceptor struct {
+	// Runs before the call. Setting Err skips the call, the after hooks still run. If they clear Err without supplying the call's result it fails with a skipped by interceptor error.
+	Before func(call *Call)
+	// Runs after the call.
+	After func(call *Call)
+}
+
+// Adds hooks that run around every open, prepare, query, exec, begin, commit, rollback, rows next and rows close. Before hooks run in the order they were added and after hooks in reverse, so the first interceptor added wraps all the others.
+func AddInterceptor(i Interceptor) {
+	d.conn.AddInterceptor(i)
+}
+
+// See the package level AddInterceptor().
+func (c *Connector) AddInterceptor(i Interceptor) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.interceptors = append(c.interceptors, i)
+}
+
+// Runs f between the before and after hooks of every interceptor.
+func (c *Connector) intercept(call *Call, f func(call *Call)) {
+	c.mu.Lock()
+	interceptors := append([]Interceptor(nil), c.interceptors...)
+	c.mu.Unlock()
+
+	for _, i := range interceptors {
+		if i.Before != nil {
+			i.Before(call)
+		}
+	}
+	if call.Err == nil {
+		f(call)
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		if interceptors[i].After != nil {
+			interceptors[i].After(call)
+		}
+	}
+}
+
+// Returns the error of an intercepted call. A call that was skipped or failed and had its error cleared by a hook has nothing to return, so missing reports that and it gets an error instead.
+func (call *Call) error(missing bool) error {
+	if call.Err == nil && missing {
+		return fmt.Errorf("testdb: %s skipped by interceptor", call.Op)
+	}
+	return call.Err
+}
+
+func (c *Connector) intercepting() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.interceptors) > 0
+}
+
+// Wraps rows so interceptors see calls to Next and Close.
+type interceptedRows struct {
+	driver.Rows
+	cn *conn
+}
+
+func (c *conn) interceptRows(r driver.Rows) driver.Rows {
+	if r == nil || !c.intercepting() {
+		return r
+	}
+	return &interceptedRows{Rows: r, cn: c}
+}
+
+func (rs *interceptedRows) Next(dest []driver.Value) error {
+	call := &Call{Op: OpRowsNext, ConnID: rs.cn.id, DSN: rs.cn.dsn}
+	rs.cn.intercept(call, func(call *Call) {
+		call.Err = rs.Rows.Next(dest)
+		if call.Err == nil {
+			call.Row = dest
+		}
+	})
+	return call.Err
+}
+
+func (rs *interceptedRows) Close() error {
+	call := &Call{Op: OpRowsClose, ConnID: rs.cn.id, DSN: rs.cn.dsn}
+	rs.cn.intercept(call, func(call *Call) {
+		call.Err = rs.Rows.Close()
+	})
+	return call.Err
+}
+
+// Runs a commit or rollback of t between the hooks of the connection that began it.
+func (t *Tx) intercept(op Op, f func() error) error {
+	if t.conn == nil {
+		return f()
+	}
+
+	call := &Call{Op: op, ConnID: t.conn.id, DSN: t.conn.dsn}
+	t.conn.intercept(call, func(call *Call) {
+		call.Err = f()
+	})
+	return call.Err
+}
+
+// Runs an open between the hooks of the Connector.
+func (c *Connector) interceptOpen(dsn string, cfg connConfig) (driver.Conn, error) {
+	var cn *conn
+	call := &Call{Op: OpOpen, DSN: dsn}
+	c.intercept(call, func(call *Call) {
+		cn, call.Err = c.open(call.DSN, cfg)
+		if cn != nil {
+			call.ConnID = cn.id
+		}
+	})
+	if err := call.error(cn == nil); err != nil {
+		if cn != nil {
+			cn.Close()
+		}
+		return nil, err
+	}
+	return cn, nil
+}
+
+// Runs a begin between the hooks of the connection.
+func (c *conn) interceptBegin(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	call := &Call{Op: OpBegin, ConnID: c.id, DSN: c.dsn}
+	c.intercept(call, func(call *Call) {
+		call.Tx, call.Err = c.begin(ctx, opts)
+	})
+	if err := call.error(call.Tx == nil); err != nil {
+		return nil, err
+	}
+	return call.Tx, nil
+}
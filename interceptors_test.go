@@ -0,0 +1,155 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInterceptorSeesEveryCall(t *testing.T) {
+	defer Reset()
+
+	var ops []string
+	AddInterceptor(Interceptor{
+		After: func(call *Call) {
+			ops = append(ops, call.Op.String())
+		},
+	})
+	StubQuery("SELECT id FROM users", RowsFromCSVString([]string{"id"}, "1"))
+
+	db, _ := sql.Open("testdb", "")
+
+	tx, _ := db.Begin()
+	rows, _ := tx.Query("SELECT id FROM users")
+	for rows.Next() {
+	}
+	rows.Close()
+	tx.Commit()
+
+	expected := []string{"open", "begin", "query", "rows next", "rows next", "rows close", "commit"}
+	if !reflect.DeepEqual(ops, expected) {
+		t.Fatal("unexpected calls", ops)
+	}
+}
+
+func TestInterceptorChangesCalls(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT id FROM users_v2", RowsFromCSVString([]string{"id"}, "2"))
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+
+	AddInterceptor(Interceptor{
+		Before: func(call *Call) {
+			call.Query = strings.Replace(call.Query, "users_v1", "users_v2", 1)
+			if call.Op == OpExec && strings.HasPrefix(call.Query, "DELETE") {
+				call.Err = errors.New("deletes are not allowed")
+			}
+		},
+	})
+
+	db, _ := sql.Open("testdb", "")
+
+	var id int
+	if err := db.QueryRow("SELECT id FROM users_v1").Scan(&id); err != nil || id != 2 {
+		t.Fatal("expected the rewritten query to be run, got", id, err)
+	}
+
+	if _, err := db.Exec("DELETE FROM users"); err == nil || err.Error() != "deletes are not allowed" {
+		t.Fatal("expected the before hook to skip the exec, got", err)
+	}
+	if log := QueryLog(); len(log) != 1 {
+		t.Fatal("skipped calls should not be run", log)
+	}
+}
+
+func TestInterceptorClearsErrorWithoutResult(t *testing.T) {
+	defer Reset()
+
+	SetMaxConns(1)
+	AddInterceptor(Interceptor{
+		Before: func(call *Call) {
+			if call.Op == OpQuery {
+				call.Err = errors.New("skipped")
+			}
+		},
+		After: func(call *Call) {
+			call.Err = nil
+		},
+	})
+
+	db, _ := sql.Open("testdb", "")
+	db.SetMaxIdleConns(0)
+
+	if _, err := db.Query("SELECT id FROM users"); err == nil || err.Error() != "testdb: query skipped by interceptor" {
+		t.Fatal("expected a skipped query to fail, got", err)
+	}
+
+	conn, _ := db.Conn(context.Background())
+	defer conn.Close()
+	if _, err := db.Conn(context.Background()); err == nil || err.Error() != "testdb: open skipped by interceptor" {
+		t.Fatal("expected a failed open to fail, got", err)
+	}
+}
+
+type recordingTx struct {
+	driver.Tx
+	committed bool
+}
+
+func (tx *recordingTx) Commit() error {
+	tx.committed = true
+	return tx.Tx.Commit()
+}
+
+func TestInterceptorChangesResults(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT id FROM users", RowsFromCSVString([]string{"id"}, "1"))
+
+	var stmts int
+	tx := &recordingTx{}
+	AddInterceptor(Interceptor{
+		Before: func(call *Call) {
+			if call.Op == OpOpen {
+				call.DSN = "rewritten"
+			}
+		},
+		After: func(call *Call) {
+			switch call.Op {
+			case OpBegin:
+				tx.Tx = call.Tx
+				call.Tx = tx
+			case OpPrepare:
+				if call.Stmt != nil {
+					stmts++
+				}
+			}
+		},
+	})
+
+	db, _ := sql.Open("testdb", "")
+
+	for i := 0; i < 2; i++ {
+		rows, _ := db.Query("SELECT id FROM users")
+		rows.Close()
+	}
+	if log := QueryLog(); log[0].DSN != "rewritten" || ConnsOpened() != 1 {
+		t.Fatal("expected the connection to report the rewritten DSN and stay in the pool", log, ConnsOpened())
+	}
+
+	stmt, _ := db.Prepare("SELECT id FROM users")
+	stmt.Close()
+	if stmts != 1 {
+		t.Fatal("expected the after hook to see the prepared statement")
+	}
+
+	sqlTx, _ := db.Begin()
+	sqlTx.Commit()
+	if !tx.committed {
+		t.Fatal("expected the transaction returned by the hook to be used")
+	}
+}
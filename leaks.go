@@ -0,0 +1,148 @@
+package testdb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var pkgDir string
+
+func init() {
+	_, file, _, _ := runtime.Caller(0)
+	pkgDir = filepath.Dir(file)
+}
+
+// Returns file:line of the first caller outside of testdb and database/sql, which is where the application asked for the object being tracked.
+func callerLocation() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+
+	for {
+		f, more := frames.Next()
+		internal := strings.HasPrefix(f.Function, "database/sql.") ||
+			strings.HasPrefix(f.Function, "runtime.") ||
+			(filepath.Dir(f.File) == pkgDir && !strings.HasSuffix(f.File, "_test.go"))
+
+		if !internal {
+			return fmt.Sprintf("%s:%d", f.File, f.Line)
+		}
+		if !more {
+			return "unknown location"
+		}
+	}
+}
+
+// Embedded in objects the driver hands out which must be closed by the caller.
+type tracked struct {
+	mu        sync.Mutex
+	closed    bool
+	createdAt string
+}
+
+func (t *tracked) track() {
+	t.createdAt = callerLocation()
+}
+
+func (t *tracked) close() {
+	t.mu.Lock()
+	t.closed = true
+	t.mu.Unlock()
+}
+
+func (t *tracked) isClosed() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed
+}
+
+func problemsError(problems []string) error {
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("testdb: " + strings.Join(problems, "\n\t"))
+}
+
+// Returns every transaction started on the global driver.Conn since the last Reset.
+func Transactions() []*Tx {
+	return d.conn.Transactions()
+}
+
+// See the package level Transactions().
+func (c *Connector) Transactions() []*Tx {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Tx(nil), c.txs...)
+}
+
+// Returns an error describing every transaction that was never committed or rolled back, or that was finished more than once. Call it at the end of a test to catch a missing defer tx.Rollback().
+func CheckTransactions() error {
+	return d.conn.CheckTransactions()
+}
+
+// See the package level CheckTransactions().
+func (c *Connector) CheckTransactions() error {
+	var problems []string
+	for _, t := range c.Transactions() {
+		if p := t.problem(); p != "" {
+			problems = append(problems, p)
+		}
+	}
+	return problemsError(problems)
+}
+
+// Registers a cleanup function with tb that fails the test if any transaction begun during it was never committed or rolled back, or was finished more than once. It keeps working if Reset is deferred by the test.
+func FailOnLeakedTransactions(tb testing.TB) {
+	d.conn.FailOnLeakedTransactions(tb)
+}
+
+// See the package level FailOnLeakedTransactions().
+func (c *Connector) FailOnLeakedTransactions(tb testing.TB) {
+	tb.Cleanup(func() {
+		if err := c.CheckTransactions(); err != nil {
+			tb.Error(err)
+		}
+	})
+}
+
+// Returns an error describing every rows and statement object handed out by the global driver.Conn that was never closed, along with where it was created.
+func CheckLeaks() error {
+	return d.conn.CheckLeaks()
+}
+
+// See the package level CheckLeaks().
+func (c *Connector) CheckLeaks() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var problems []string
+	for _, r := range c.rows {
+		if !r.isClosed() {
+			problems = append(problems, "rows opened at "+r.createdAt+" were never closed")
+		}
+	}
+	for _, s := range c.stmts {
+		if !s.isClosed() {
+			problems = append(problems, "statement prepared at "+s.createdAt+" was never closed")
+		}
+	}
+	return problemsError(problems)
+}
+
+// Registers a cleanup function with tb that fails the test if any rows or statements handed out during it were never closed. It keeps working if Reset is deferred by the test.
+func FailOnLeaks(tb testing.TB) {
+	d.conn.FailOnLeaks(tb)
+}
+
+// See the package level FailOnLeaks().
+func (c *Connector) FailOnLeaks(tb testing.TB) {
+	tb.Cleanup(func() {
+		if err := c.CheckLeaks(); err != nil {
+			tb.Error(err)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package testdb
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckTransactions(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	committed, _ := db.Begin()
+	committed.Commit()
+
+	rolledBack, _ := db.Begin()
+	rolledBack.Rollback()
+
+	if err := CheckTransactions(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Begin()
+
+	err := CheckTransactions()
+	if err == nil || !strings.Contains(err.Error(), "never committed or rolled back") {
+		t.Fatal("leaked transaction was not reported")
+	}
+
+	if !strings.Contains(err.Error(), "leaks_test.go") {
+		t.Fatalf("leaked transaction should report where it was begun: %s", err)
+	}
+}
+
+func TestCheckTransactionsDoubleFinish(t *testing.T) {
+	defer Reset()
+
+	tx, _ := Conn().Begin()
+	tx.Commit()
+	tx.Rollback()
+
+	err := CheckTransactions()
+	if err == nil || !strings.Contains(err.Error(), "finished 2 times (commit, rollback)") {
+		t.Fatal("double finished transaction was not reported")
+	}
+}
+
+func TestFailOnLeakedTransactions(t *testing.T) {
+	tb := &fakeTB{TB: t}
+	FailOnLeakedTransactions(tb)
+
+	db, _ := sql.Open("testdb", "")
+	db.Begin()
+	Reset()
+
+	tb.finish()
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "never committed or rolled back") {
+		t.Fatalf("leaked transaction was not reported at cleanup: %v", tb.errors)
+	}
+}
+
+func TestTxState(t *testing.T) {
+	defer Reset()
+
+	StubCommitError(errors.New("commit failed"))
+
+	db, _ := sql.Open("testdb", "")
+
+	tx, _ := db.Begin()
+	if LastTx().State() != TxOpen {
+		t.Fatal("new transaction should be open")
+	}
+
+	tx.Commit()
+	if LastTx().State() != TxFailed {
+		t.Fatal("transaction with failed commit should be marked as failed")
+	}
+
+	tx, _ = db.Begin()
+	tx.Rollback()
+	if LastTx().State() != TxRolledBack {
+		t.Fatal("transaction should be rolled back")
+	}
+
+	if len(Transactions()) != 2 {
+		t.Fatal("failed to track every transaction")
+	}
+}
+
+func TestCheckLeaksRows(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "5"))
+
+	closed, _ := db.Query(sql)
+	closed.Close()
+
+	var count int
+	db.QueryRow(sql).Scan(&count)
+
+	if err := CheckLeaks(); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Query(sql)
+
+	err := CheckLeaks()
+	if err == nil || !strings.Contains(err.Error(), "leaks_test.go") {
+		t.Fatal("leaked rows were not reported with where they were opened")
+	}
+}
+
+func TestCheckLeaksStmt(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	sql := "select count(*) from foo"
+	StubQuery(sql, RowsFromCSVString([]string{"count"}, "5"))
+
+	stmt, _ := db.Prepare(sql)
+
+	err := CheckLeaks()
+	if err == nil || !strings.Contains(err.Error(), "statement prepared at") {
+		t.Fatal("leaked statement was not reported")
+	}
+
+	stmt.Close()
+
+	if err := CheckLeaks(); err != nil {
+		t.Fatal(err)
+	}
+}
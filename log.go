@@ -0,0 +1,127 @@
+package testdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// A query or exec call handled by the driver.
+type QueryLogEntry struct {
+	// ID of the connection which served the call, connections are numbered from 1 in the order they were opened
+	ConnID int64
+	// DSN the connection was opened with
+	DSN   string
+	Query string
+	Args  []driver.NamedValue
+	Exec  bool
+	Err   error
+
+	// Orders entries logged by different connectors
+	seq int64
+}
+
+var logSeq int64
+
+func (c *Connector) logQuery(cn *conn, query string, args []driver.NamedValue, exec bool, err error) {
+	entry := QueryLogEntry{
+		ConnID: cn.id,
+		DSN:    cn.dsn,
+		Query:  query,
+		Args:   args,
+		Exec:   exec,
+		Err:    err,
+		seq:    atomic.AddInt64(&logSeq, 1),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = append(c.log, entry)
+
+	// Wake anyone in WaitForQuery
+	if c.logged != nil {
+		close(c.logged)
+		c.logged = nil
+	}
+}
+
+// Returns every query and exec call made against the global driver.Conn since the last Reset, in the order they were made. Named arguments keep their names.
+func QueryLog() []QueryLogEntry {
+	return d.conn.QueryLog()
+}
+
+// See the package level QueryLog().
+func (c *Connector) QueryLog() []QueryLogEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]QueryLogEntry(nil), c.log...)
+}
+
+// Blocks until a query or exec call matching q has run, or returns an error listing the queries that did run if ctx is done first. Calls made since the last Reset count, so it returns straight away if q has already run. Query matching is case insensitive, and whitespace is also ignored.
+func WaitForQuery(ctx context.Context, q string) error {
+	return d.conn.WaitForQuery(ctx, q)
+}
+
+// Like WaitForQuery(), but blocks until q has run at least n times.
+func WaitForQueryN(ctx context.Context, q string, n int) error {
+	return d.conn.WaitForQueryN(ctx, q, n)
+}
+
+// See the package level WaitForQuery().
+func (c *Connector) WaitForQuery(ctx context.Context, q string) error {
+	return c.WaitForQueryN(ctx, q, 1)
+}
+
+// See the package level WaitForQueryN().
+func (c *Connector) WaitForQueryN(ctx context.Context, q string, n int) error {
+	hash := getQueryHash(q)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.timesRun(hash) >= n {
+			return nil
+		}
+
+		if c.logged == nil {
+			c.logged = make(chan struct{})
+		}
+		logged := c.logged
+		c.mu.Unlock()
+
+		select {
+		case <-logged:
+			c.mu.Lock()
+		case <-ctx.Done():
+			c.mu.Lock()
+			return c.waitError(ctx.Err(), q, n)
+		}
+	}
+}
+
+// Must be called with mu held.
+func (c *Connector) timesRun(hash string) int {
+	ran := 0
+	for _, entry := range c.log {
+		if getQueryHash(entry.Query) == hash {
+			ran++
+		}
+	}
+	return ran
+}
+
+// Must be called with mu held.
+func (c *Connector) waitError(err error, q string, n int) error {
+	queries := "no queries were run"
+	if len(c.log) > 0 {
+		run := make([]string, len(c.log))
+		for i, entry := range c.log {
+			run[i] = entry.Query
+		}
+		queries = "queries run:\n\t" + strings.Join(run, "\n\t")
+	}
+	return fmt.Errorf("testdb: %w waiting for %q, it ran %d of %d times, %s", err, q, c.timesRun(getQueryHash(q)), n, queries)
+}
@@ -0,0 +1,56 @@
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForQuery(t *testing.T) {
+	defer Reset()
+
+	q := "UPDATE outbox SET sent = true WHERE id = ?"
+	StubExec(q, NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+
+	go func() {
+		for i := 0; i < 3; i++ {
+			db.Exec(q, i)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := WaitForQuery(ctx, q); err != nil {
+		t.Fatal(err)
+	}
+	if err := WaitForQueryN(ctx, "update outbox set sent = true where id = ?", 3); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitForQueryTimeout(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT id FROM outbox", RowsFromCSVString([]string{"id"}, "1"))
+
+	db, _ := sql.Open("testdb", "")
+	rows, _ := db.Query("SELECT id FROM outbox")
+	rows.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	err := WaitForQuery(ctx, "DELETE FROM outbox")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("expected the context's error, got", err)
+	}
+	if !strings.Contains(err.Error(), "it ran 0 of 1 times, queries run:\n\tSELECT id FROM outbox") {
+		t.Fatal("expected the error to list the queries that ran, got", err)
+	}
+}
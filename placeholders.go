@@ -0,0 +1,163 @@
+package testdb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Counts the bind parameters in a query, ? and $N are positional while :name and @name count each distinct name once.
+type placeholders struct {
+	question int
+	dollar   int
+	named    map[string]bool
+	at       map[string]bool
+}
+
+// Returns the number of placeholders of the supplied style, see the Placeholders constants.
+func (p placeholders) count(style string) int {
+	switch style {
+	case PlaceholdersQuestion:
+		return p.question
+	case PlaceholdersDollar:
+		return p.dollar
+	case PlaceholdersNamed:
+		return len(p.named)
+	case PlaceholdersAt:
+		return len(p.at)
+	}
+	return p.question + p.dollar + len(p.named) + len(p.at)
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Scans query for ?, $N, :name and @name placeholders, skipping string literals, quoted identifiers, dollar quoted strings, MySQL system variables and comments. A backslash escapes the next character in E'...' literals, and in every string literal when backslashEscapes is set, as in MySQL.
+func parsePlaceholders(query string, backslashEscapes bool) placeholders {
+	p := placeholders{named: make(map[string]bool), at: make(map[string]bool)}
+
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			escapes := c != '`' && backslashEscapes || c == '\'' && isEscapeString(query, i)
+			i = closingQuote(query, i, escapes)
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			if end := strings.IndexByte(query[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(query)
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += end + 3
+			} else {
+				i = len(query)
+			}
+		case c == '?':
+			p.question++
+		case c == '$':
+			j := i + 1
+			for j < len(query) && isDigit(query[j]) {
+				j++
+			}
+			if j > i+1 {
+				var n int
+				fmt.Sscan(query[i+1:j], &n)
+				if n > p.dollar {
+					p.dollar = n
+				}
+				i = j - 1
+				continue
+			}
+
+			// $tag$ ... $tag$ is a postgres dollar quoted string
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			if j < len(query) && query[j] == '$' {
+				tag := query[i : j+1]
+				if end := strings.Index(query[j+1:], tag); end >= 0 {
+					i = j + end + len(tag)
+				} else {
+					i = len(query)
+				}
+			}
+		case c == ':':
+			// :: is a postgres type cast
+			if i+1 < len(query) && query[i+1] == ':' {
+				i++
+				continue
+			}
+			if name := identAt(query, i+1); name != "" && !isDigit(name[0]) {
+				p.named[name] = true
+				i += len(name)
+			}
+		case c == '@':
+			// @@name is a MySQL system variable
+			if i+1 < len(query) && query[i+1] == '@' {
+				i += len(identAt(query, i+2)) + 1
+				continue
+			}
+			if name := identAt(query, i+1); name != "" && !isDigit(name[0]) {
+				p.at[strings.ToLower(name)] = true
+				i += len(name)
+			}
+		case isIdentChar(c):
+			// Skip the rest of the word so a $ or : inside an identifier isn't read as a placeholder
+			i += len(identAt(query, i)) - 1
+		}
+	}
+
+	return p
+}
+
+// Reports whether the literal starting at i is a postgres E'...' string.
+func isEscapeString(query string, i int) bool {
+	return i > 0 && (query[i-1] == 'E' || query[i-1] == 'e') && (i == 1 || !isIdentChar(query[i-2]))
+}
+
+// Returns the index of the quote closing the literal starting at i, or the end of the query. Doubled quotes escape the quote character so they simply start the next literal.
+func closingQuote(query string, i int, escapes bool) int {
+	quote := query[i]
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if escapes {
+				j++
+			}
+		case quote:
+			return j
+		}
+	}
+	return len(query)
+}
+
+func identAt(s string, i int) string {
+	j := i
+	for j < len(s) && isIdentChar(s[j]) {
+		j++
+	}
+	return s[i:j]
+}
+
+// Returns the number of placeholders in query, or -1 if placeholder counting is disabled.
+func (c *conn) numInput(query string) int {
+	if !c.config.strict && !d.countPlaceholders {
+		return -1
+	}
+	// Postgres treats backslashes in plain literals as ordinary characters
+	escapes := c.config.placeholders != PlaceholdersDollar
+	return parsePlaceholders(query, escapes).count(c.config.placeholders)
+}
+
+// Mirrors the check database/sql performs for prepared statements, for queries that go straight to the conn.
+func (c *conn) checkNumInput(query string, args int) error {
+	if n := c.numInput(query); n >= 0 && n != args {
+		return fmt.Errorf("sql: expected %d arguments, got %d", n, args)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package testdb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestParsePlaceholders(t *testing.T) {
+	tests := []struct {
+		query string
+		count int
+	}{
+		{"SELECT * FROM users", 0},
+		{"SELECT * FROM users WHERE id = ? AND name = ?", 2},
+		{"SELECT * FROM users WHERE id = $1 AND (name = $2 OR nick = $2)", 2},
+		{"SELECT * FROM users WHERE id = :id AND (name = :name OR nick = :name)", 2},
+		{"SELECT * FROM users WHERE id = @p1 AND name = @p2", 2},
+		{"SELECT '?', \"$1\", `:id` FROM users WHERE id = ?", 1},
+		{"SELECT 'it''s ?' FROM users WHERE id = ?", 1},
+		{"SELECT id -- where id = ?\nFROM users /* :name */ WHERE id = $1", 1},
+		{"SELECT created_at::date FROM users WHERE id = :id", 1},
+		{"SELECT $$ ? $$, $tag$ $1 $tag$ FROM users WHERE id = $1", 1},
+		{"SELECT '10:30' FROM users", 0},
+		{"SELECT @@version", 0},
+		{"SELECT @@session.sql_mode FROM users WHERE id = ?", 1},
+		{`SELECT 'it\'s' FROM users WHERE id = ?`, 1},
+		{`SELECT "say \"?\"" FROM users WHERE id = ?`, 1},
+		{`SELECT 'C:\\' FROM users WHERE id = ?`, 1},
+	}
+
+	for _, test := range tests {
+		if n := parsePlaceholders(test.query, true).count(PlaceholdersAny); n != test.count {
+			t.Errorf("expected %d placeholders in %q, got %d", test.count, test.query, n)
+		}
+	}
+
+	// Without backslash escapes only E'...' strings treat a backslash as an escape
+	postgres := []struct {
+		query string
+		count int
+	}{
+		{`SELECT 'C:\' FROM users WHERE id = $1`, 1},
+		{`SELECT E'it\'s $1' FROM users WHERE id = $1`, 1},
+		{`SELECT name FROM users WHERE name = 'E\' AND id = $1`, 1},
+	}
+
+	for _, test := range postgres {
+		if n := parsePlaceholders(test.query, false).count(PlaceholdersDollar); n != test.count {
+			t.Errorf("expected %d placeholders in %q, got %d", test.count, test.query, n)
+		}
+	}
+}
+
+func TestEnablePlaceholderCounting(t *testing.T) {
+	defer Reset()
+	defer EnablePlaceholderCounting(false)
+
+	EnablePlaceholderCounting(true)
+
+	db, _ := sql.Open("testdb", "")
+
+	query := "SELECT name FROM users WHERE id = ?"
+	StubQuery(query, RowsFromCSVString([]string{"name"}, "tim"))
+
+	if _, err := db.Query(query, 1, 2); err == nil || err.Error() != "sql: expected 1 arguments, got 2" {
+		t.Fatalf("mismatched arguments should fail, got %v", err)
+	}
+
+	stmt, _ := db.Prepare(query)
+	defer stmt.Close()
+
+	if _, err := stmt.Query(); err == nil {
+		t.Fatal("prepared statement with mismatched arguments should fail")
+	}
+
+	rows, err := stmt.Query(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+}
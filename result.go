@@ -1,10 +1,18 @@
 package testdb
 
+import (
+	"database/sql/driver"
+	"errors"
+)
+
 type Result struct {
 	lastInsertId      int64
 	lastInsertIdError error
 	rowsAffected      int64
 	rowsAffectedError error
+
+	// Hands out the LastInsertId of each exec, when set
+	seq *Sequence
 }
 
 func NewResult(lastId int64, lastIdError error, rowsAffected int64, rowsAffectedError error) (res *Result) {
@@ -16,6 +24,11 @@ func NewResult(lastId int64, lastIdError error, rowsAffected int64, rowsAffected
 	}
 }
 
+// Creates a Result whose LastInsertId is the next value from seq every time it's returned by an exec, so code inserting rows in a loop gets a new ID for each.
+func NewResultWithSequence(seq *Sequence, rowsAffected int64) *Result {
+	return &Result{seq: seq, rowsAffected: rowsAffected}
+}
+
 func (res *Result) LastInsertId() (int64, error) {
 	return res.lastInsertId, res.lastInsertIdError
 }
@@ -23,3 +36,25 @@ func (res *Result) LastInsertId() (int64, error) {
 func (res *Result) RowsAffected() (int64, error) {
 	return res.rowsAffected, res.rowsAffectedError
 }
+
+// Returns the result for a single exec, taking the next ID from the sequence if there is one.
+func (res *Result) forExec() *Result {
+	if res.seq == nil {
+		return res
+	}
+	r := *res
+	r.seq = nil
+	r.lastInsertId = res.seq.Next()
+	return &r
+}
+
+var errLastInsertIdUnsupported = errors.New("LastInsertId is not supported by this driver")
+
+// Wraps results when LastInsertId is unsupported, as it is on Postgres.
+type noLastInsertIdResult struct {
+	driver.Result
+}
+
+func (noLastInsertIdResult) LastInsertId() (int64, error) {
+	return 0, errLastInsertIdUnsupported
+}
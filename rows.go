@@ -6,7 +6,7 @@ import (
 )
 
 type rows struct {
-	closed  bool
+	tracked
 	columns []string
 	rows    [][]driver.Value
 	pos     int
@@ -17,14 +17,12 @@ func (rs *rows) clone() *rows {
 		return nil
 	}
 
-	return &rows{closed: false, columns: rs.columns, rows: rs.rows, pos: 0}
+	return &rows{columns: rs.columns, rows: rs.rows, pos: 0}
 }
 
 func (rs *rows) Next(dest []driver.Value) error {
 	rs.pos++
 	if rs.pos > len(rs.rows) {
-		rs.closed = true
-
 		return io.EOF // per interface spec
 	}
 
@@ -44,5 +42,6 @@ func (rs *rows) Columns() []string {
 }
 
 func (rs *rows) Close() error {
+	rs.close()
 	return nil
 }
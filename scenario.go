@@ -0,0 +1,116 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"sync"
+)
+
+// The state every scenario starts in.
+const ScenarioStarted = "Started"
+
+// A Scenario is a state machine for stubs. Its stubs are only used while the scenario is in the state they were registered for, and running certain execs moves it to a new state, so the same query can return different rows before and after an update.
+type Scenario struct {
+	c    *Connector
+	name string
+
+	mu          sync.Mutex
+	state       string
+	transitions []transition
+}
+
+type transition struct {
+	from string
+	sql  string
+	to   string
+}
+
+// Creates a scenario in the ScenarioStarted state.
+func NewScenario(name string) *Scenario {
+	return d.conn.NewScenario(name)
+}
+
+// See the package level NewScenario().
+func (c *Connector) NewScenario(name string) *Scenario {
+	sc := &Scenario{c: c, name: name, state: ScenarioStarted}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scenarios = append(c.scenarios, sc)
+	return sc
+}
+
+// Returns the scenario's name.
+func (sc *Scenario) Name() string {
+	return sc.name
+}
+
+// Returns the state the scenario is in.
+func (sc *Scenario) State() string {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.state
+}
+
+// Moves the scenario to state.
+func (sc *Scenario) SetState(state string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.state = state
+}
+
+// Moves the scenario back to ScenarioStarted.
+func (sc *Scenario) Reset() {
+	sc.SetState(ScenarioStarted)
+}
+
+// Stubs the rows returned for q while the scenario is in state.
+func (sc *Scenario) StubQuery(state, q string, rows driver.Rows) {
+	sc.c.stub(q, &query{rows: rows, scenario: sc, state: state})
+}
+
+// Stubs the error returned for q while the scenario is in state.
+func (sc *Scenario) StubQueryError(state, q string, err error) {
+	sc.c.stub(q, &query{err: err, scenario: sc, state: state})
+}
+
+// Stubs the result of executing q while the scenario is in state.
+func (sc *Scenario) StubExec(state, q string, r *Result) {
+	sc.c.stub(q, &query{result: r, scenario: sc, state: state})
+}
+
+// Stubs the error returned for executing q while the scenario is in state.
+func (sc *Scenario) StubExecError(state, q string, err error) {
+	sc.StubQueryError(state, q, err)
+}
+
+// Moves the scenario from one state to another when q is executed successfully while it's in from. The exec is still handled by the stubs as usual. Query matching follows the connection's normalization.
+func (sc *Scenario) Transition(from, q, to string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.transitions = append(sc.transitions, transition{from: from, sql: q, to: to})
+}
+
+// Applies the first transition out of the current state matching query.
+func (sc *Scenario) apply(cfg connConfig, query string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	hash := getQueryHash(query)
+	for _, t := range sc.transitions {
+		if t.from == sc.state && getQueryHash(t.sql) == hash && cfg.matches(t.sql, query) {
+			sc.state = t.to
+			return
+		}
+	}
+}
+
+// Moves every scenario whose transitions match an exec that succeeded.
+func (c *conn) transition(query string) {
+	c.mu.Lock()
+	scenarios := append([]*Scenario(nil), c.scenarios...)
+	c.mu.Unlock()
+
+	for _, sc := range scenarios {
+		sc.apply(c.config, query)
+	}
+}
@@ -0,0 +1,73 @@
+package testdb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestScenario(t *testing.T) {
+	defer Reset()
+
+	status := "SELECT status FROM orders WHERE id = ?"
+	ship := "UPDATE orders SET status = 'shipped' WHERE id = ?"
+
+	sc := NewScenario("order")
+	sc.StubQuery(ScenarioStarted, status, RowsFromCSVString([]string{"status"}, "pending"))
+	sc.StubQuery("shipped", status, RowsFromCSVString([]string{"status"}, "shipped"))
+	sc.StubExec(ScenarioStarted, ship, NewResult(0, nil, 1, nil))
+	sc.StubExecError("shipped", ship, UniqueViolation("orders", "orders_shipped"))
+	sc.Transition(ScenarioStarted, ship, "shipped")
+
+	db, _ := sql.Open("testdb", "")
+
+	var s string
+	db.QueryRow(status, 1).Scan(&s)
+	if s != "pending" {
+		t.Fatal("expected the order to be pending before the update, got", s)
+	}
+
+	if _, err := db.Exec(ship, 1); err != nil {
+		t.Fatal(err)
+	}
+	if sc.State() != "shipped" {
+		t.Fatal("expected the exec to move the scenario to shipped, got", sc.State())
+	}
+
+	db.QueryRow(status, 1).Scan(&s)
+	if s != "shipped" {
+		t.Fatal("expected the order to be shipped after the update, got", s)
+	}
+	if _, err := db.Exec(ship, 1); err == nil {
+		t.Fatal("expected the stub for the shipped state to be used")
+	}
+
+	sc.Reset()
+	db.QueryRow(status, 1).Scan(&s)
+	if s != "pending" {
+		t.Fatal("expected Reset to move the scenario back to the start, got", s)
+	}
+}
+
+func TestScenarioFallsBackToStubs(t *testing.T) {
+	defer Reset()
+
+	q := "SELECT status FROM orders"
+	StubQuery(q, RowsFromCSVString([]string{"status"}, "unknown"))
+
+	sc := NewScenario("order")
+	sc.StubQuery("shipped", q, RowsFromCSVString([]string{"status"}, "shipped"))
+
+	db, _ := sql.Open("testdb", "")
+
+	var s string
+	db.QueryRow(q).Scan(&s)
+	if s != "unknown" {
+		t.Fatal("expected the plain stub outside the scenario's state, got", s)
+	}
+
+	sc.SetState("shipped")
+	db.QueryRow(q).Scan(&s)
+	if s != "shipped" {
+		t.Fatal("expected the scenario stub to win in its state, got", s)
+	}
+}
@@ -0,0 +1,95 @@
+package testdb
+
+import "sync"
+
+// Hands out increasing IDs for LastInsertId, see NewSequence().
+type Sequence struct {
+	mu    sync.Mutex
+	start int64
+	next  int64
+}
+
+func newSequence(start int64) *Sequence {
+	return &Sequence{start: start, next: start}
+}
+
+// Returns the next ID.
+func (s *Sequence) Next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	return s.next - 1
+}
+
+// Returns the ID the next call to Next() will hand out.
+func (s *Sequence) Peek() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.next
+}
+
+// Starts the sequence over from where it was created.
+func (s *Sequence) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = s.start
+}
+
+// Makes sure the sequence hands out IDs after id, used when an ID is set explicitly.
+func (s *Sequence) skipPast(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if id >= s.next {
+		s.next = id + 1
+	}
+}
+
+// Creates a sequence starting at start, use it with NewResultWithSequence() to stub execs returning a new LastInsertId each time. ResetSequences() starts it over.
+func NewSequence(start int64) *Sequence {
+	return d.conn.NewSequence(start)
+}
+
+// Starts every sequence created since the last Reset over, including those of in-memory tables.
+func ResetSequences() {
+	d.conn.ResetSequences()
+}
+
+// Makes LastInsertId return an error for every exec, as it does on Postgres. The DSN option lastinsertid=false does the same for a single connection.
+func DisableLastInsertId() {
+	d.conn.DisableLastInsertId()
+}
+
+// See the package level NewSequence().
+func (c *Connector) NewSequence(start int64) *Sequence {
+	s := newSequence(start)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sequences = append(c.sequences, s)
+	return s
+}
+
+// See the package level ResetSequences().
+func (c *Connector) ResetSequences() {
+	c.mu.Lock()
+	sequences := append([]*Sequence(nil), c.sequences...)
+	c.mu.Unlock()
+
+	for _, s := range sequences {
+		s.Reset()
+	}
+}
+
+// See the package level DisableLastInsertId().
+func (c *Connector) DisableLastInsertId() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.noLastInsertId = true
+}
+
+// Makes LastInsertId unsupported for connections from the Connector, see DisableLastInsertId().
+func WithoutLastInsertId() Option {
+	return func(c *Connector) {
+		c.noLastInsertId = true
+	}
+}
@@ -0,0 +1,91 @@
+package testdb
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestResultWithSequence(t *testing.T) {
+	defer Reset()
+
+	q := "INSERT INTO users (name) VALUES (?)"
+	StubExec(q, NewResultWithSequence(NewSequence(10), 1))
+
+	db, _ := sql.Open("testdb", "")
+
+	for _, expected := range []int64{10, 11, 12} {
+		res, err := db.Exec(q, "tim")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, _ := res.LastInsertId(); id != expected {
+			t.Fatalf("expected last insert id %d, got %d", expected, id)
+		}
+	}
+
+	ResetSequences()
+
+	res, _ := db.Exec(q, "tim")
+	if id, _ := res.LastInsertId(); id != 10 {
+		t.Fatalf("expected the sequence to start over at 10, got %d", id)
+	}
+}
+
+func TestTableAutoIncrement(t *testing.T) {
+	defer Reset()
+
+	users := CreateTable("users", []string{"id", "name"},
+		[]interface{}{1, "tim"},
+		[]interface{}{5, "joe"},
+	).AutoIncrement("id")
+
+	db, _ := sql.Open("testdb", "")
+
+	res, _ := db.Exec("INSERT INTO users (name) VALUES ('bob')")
+	if id, _ := res.LastInsertId(); id != 6 {
+		t.Fatalf("expected last insert id 6, got %d", id)
+	}
+
+	db.Exec("INSERT INTO users (id, name) VALUES (10, 'ann')")
+	res, _ = db.Exec("INSERT INTO users (id, name) VALUES (NULL, 'sam')")
+	if id, _ := res.LastInsertId(); id != 11 {
+		t.Fatalf("expected the sequence to skip past explicit ids, got %d", id)
+	}
+
+	if rows := users.Rows(); rows[4][0] != int64(11) {
+		t.Fatal("expected the id column to be filled in", rows[4])
+	}
+
+	ResetSequences()
+	if next := users.Sequence().Peek(); next != 6 {
+		t.Fatalf("expected the sequence to start over after the seed rows, got %d", next)
+	}
+}
+
+func TestDisableLastInsertId(t *testing.T) {
+	defer Reset()
+
+	q := "INSERT INTO users (name) VALUES (?)"
+	StubExec(q, NewResult(1, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+	res, _ := db.Exec(q, "tim")
+	if _, err := res.LastInsertId(); err != nil {
+		t.Fatal(err)
+	}
+
+	pg, _ := sql.Open("testdb", "?lastinsertid=false")
+	res, _ = pg.Exec(q, "tim")
+	if _, err := res.LastInsertId(); err == nil {
+		t.Fatal("expected the DSN option to make LastInsertId unsupported")
+	}
+
+	DisableLastInsertId()
+	res, _ = db.Exec(q, "tim")
+	if _, err := res.LastInsertId(); err != errLastInsertIdUnsupported {
+		t.Fatal("expected LastInsertId to be unsupported, got", err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatal("RowsAffected should still be supported")
+	}
+}
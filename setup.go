@@ -0,0 +1,75 @@
+package testdb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Resets the global driver.Conn, applies the options to it, and registers a cleanup function with tb. When the test finishes the cleanup reports any transaction that wasn't finished properly and any rows or statements that were never closed through tb.Errorf, logs every query run if the test failed, and resets again. Tests using Setup() can't run in parallel, give each parallel test its own NewConnector(WithT(t)) instead.
+func Setup(tb testing.TB, options ...Option) {
+	Reset()
+
+	c := d.conn.Connector
+	for _, option := range options {
+		option(c)
+	}
+
+	tb.Cleanup(func() {
+		c.cleanup(tb)
+		Reset()
+	})
+}
+
+// Registers the same cleanup as Setup() with tb for a Connector created by NewConnector().
+func WithT(tb testing.TB) Option {
+	return func(c *Connector) {
+		tb.Cleanup(func() {
+			c.cleanup(tb)
+		})
+	}
+}
+
+func (c *Connector) cleanup(tb testing.TB) {
+	tb.Helper()
+
+	if err := c.CheckTransactions(); err != nil {
+		tb.Errorf("%v", err)
+	}
+	if err := c.CheckLeaks(); err != nil {
+		tb.Errorf("%v", err)
+	}
+	if c.checkUnused {
+		if err := c.CheckUnusedStubs(); err != nil {
+			tb.Errorf("%v", err)
+		}
+	}
+	if tb.Failed() {
+		tb.Logf("%s", c.formatLog())
+	}
+}
+
+// Describes every call in the query log, one per line.
+func (c *Connector) formatLog() string {
+	log := c.QueryLog()
+	if len(log) == 0 {
+		return "testdb: no queries were run"
+	}
+
+	lines := make([]string, len(log))
+	for i, entry := range log {
+		kind := "query"
+		if entry.Exec {
+			kind = "exec"
+		}
+		line := fmt.Sprintf("[conn %d] %s %s", entry.ConnID, kind, entry.Query)
+		if len(entry.Args) > 0 {
+			line += fmt.Sprintf(" %v", values(entry.Args))
+		}
+		if entry.Err != nil {
+			line += " failed: " + strings.SplitN(entry.Err.Error(), "\n", 2)[0]
+		}
+		lines[i] = line
+	}
+	return "testdb: queries run:\n\t" + strings.Join(lines, "\n\t")
+}
@@ -0,0 +1,98 @@
+package testdb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Records what's reported to it instead of failing the real test.
+type fakeTB struct {
+	testing.TB
+	errors   []string
+	logs     []string
+	cleanups []func()
+}
+
+func (tb *fakeTB) Errorf(format string, args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *fakeTB) Error(args ...interface{}) {
+	tb.errors = append(tb.errors, fmt.Sprint(args...))
+}
+
+func (tb *fakeTB) Logf(format string, args ...interface{}) {
+	tb.logs = append(tb.logs, fmt.Sprintf(format, args...))
+}
+
+func (tb *fakeTB) Failed() bool {
+	return len(tb.errors) > 0
+}
+
+func (tb *fakeTB) Cleanup(f func()) {
+	tb.cleanups = append(tb.cleanups, f)
+}
+
+func (tb *fakeTB) finish() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+func TestSetup(t *testing.T) {
+	tb := &fakeTB{TB: t}
+	Setup(tb, WithPlaceholderCounting())
+
+	StubQuery("SELECT id FROM users WHERE id = ?", RowsFromCSVString([]string{"id"}, "1"))
+
+	db, _ := sql.Open("testdb", "")
+	if _, err := db.Query("SELECT id FROM users WHERE id = ?"); err == nil {
+		t.Fatal("expected the option to turn on placeholder counting")
+	}
+	db.Query("SELECT id FROM users WHERE id = ?", 1)
+
+	tb.finish()
+
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "were never closed") {
+		t.Fatal("expected the leaked rows to be reported", tb.errors)
+	}
+	if len(tb.logs) != 1 || !strings.Contains(tb.logs[0], "[conn 1] query SELECT id FROM users WHERE id = ? [1]") {
+		t.Fatal("expected the query log to be dumped", tb.logs)
+	}
+	if len(d.conn.queries) != 0 {
+		t.Fatal("expected the stubs to be reset")
+	}
+}
+
+func TestSetupPassing(t *testing.T) {
+	tb := &fakeTB{TB: t}
+	Setup(tb)
+
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+	db.Exec("DELETE FROM users")
+
+	tb.finish()
+
+	if len(tb.errors) != 0 || len(tb.logs) != 0 {
+		t.Fatal("expected nothing to be reported for a passing test", tb.errors, tb.logs)
+	}
+}
+
+func TestWithT(t *testing.T) {
+	tb := &fakeTB{TB: t}
+	c := NewConnector(WithT(tb))
+
+	db := sql.OpenDB(c)
+	tx, _ := db.Begin()
+	_ = tx
+
+	tb.finish()
+
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "never committed or rolled back") {
+		t.Fatal("expected the open transaction to be reported", tb.errors)
+	}
+}
@@ -0,0 +1,195 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+// The stubs, handlers, functions and settings of a Connector at some point in time, see Snapshot().
+type StubSnapshot struct {
+	c *Connector
+
+	queries       map[string][]*query
+	queryFunc     func(query string, args []driver.NamedValue) (driver.Rows, error)
+	execFunc      func(query string, args []driver.NamedValue) (driver.Result, error)
+	beginFunc     func() (driver.Tx, error)
+	commitFunc    func() error
+	rollbackFunc  func() error
+	pingFunc      func() error
+	resetFunc     func() error
+	queryHandlers []queryHandler
+	execHandlers  []execHandler
+	savepointErrs map[SavepointOp]map[string]error
+	interceptors  []Interceptor
+
+	unstubbedPolicy UnstubbedPolicy
+	unstubbedTB     testing.TB
+	checkUnused     bool
+	noLastInsertId  bool
+
+	passThroughArgs map[reflect.Type]bool
+	argConverters   map[reflect.Type]func(interface{}) (driver.Value, error)
+	argConverter    driver.ValueConverter
+
+	badConns      map[string]int
+	beginBadConns int
+	queryGates    map[string]*Gate
+	execGates     map[string]*Gate
+	commitGate    *Gate
+
+	scenarios      []*Scenario
+	scenarioStates map[*Scenario]scenarioSnapshot
+
+	queryFuncUsage *StubUsage
+	execFuncUsage  *StubUsage
+}
+
+// The state and transitions of a scenario, see StubSnapshot.
+type scenarioSnapshot struct {
+	state       string
+	transitions []transition
+}
+
+// Captures the stubs, handlers, functions and settings of the global driver.Conn, so they can be brought back with Restore(). Subtests can share the stubs of their parent and add their own:
+//
+//	defer testdb.Restore(testdb.Snapshot())
+//
+// Along with the stubs and functions the snapshot holds the interceptors, the unstubbed policy and the test passed to FailUnstubbed(), argument conversion, StubBadConn() and StubBeginBadConn() counts, gates, scenarios with their states and transitions, and the WithUnusedStubCheck() and DisableLastInsertId() settings. The query log, attempt counts, unstubbed calls, transactions, tables, sequences, connections and the SetMaxConns() and FailOpensAfter() limits aren't part of the snapshot.
+func Snapshot() *StubSnapshot {
+	return d.conn.Snapshot()
+}
+
+// Puts back everything captured by Snapshot(), dropping anything registered since. It panics if Reset was called after the snapshot was taken.
+func Restore(s *StubSnapshot) {
+	d.conn.Restore(s)
+}
+
+// See the package level Snapshot().
+func (c *Connector) Snapshot() *StubSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &StubSnapshot{
+		c:               c,
+		queries:         c.queries,
+		queryFunc:       c.queryFunc,
+		execFunc:        c.execFunc,
+		beginFunc:       c.beginFunc,
+		commitFunc:      c.commitFunc,
+		rollbackFunc:    c.rollbackFunc,
+		pingFunc:        c.pingFunc,
+		resetFunc:       c.resetFunc,
+		queryHandlers:   c.queryHandlers,
+		execHandlers:    c.execHandlers,
+		savepointErrs:   c.savepointErrs,
+		interceptors:    c.interceptors,
+		unstubbedPolicy: c.unstubbedPolicy,
+		unstubbedTB:     c.unstubbedTB,
+		checkUnused:     c.checkUnused,
+		noLastInsertId:  c.noLastInsertId,
+		passThroughArgs: c.passThroughArgs,
+		argConverters:   c.argConverters,
+		argConverter:    c.argConverter,
+		badConns:        c.badConns,
+		beginBadConns:   c.beginBadConns,
+		queryGates:      c.queryGates,
+		execGates:       c.execGates,
+		commitGate:      c.commitGate,
+		scenarios:       c.scenarios,
+		scenarioStates:  make(map[*Scenario]scenarioSnapshot, len(c.scenarios)),
+		queryFuncUsage:  c.queryFuncUsage,
+		execFuncUsage:   c.execFuncUsage,
+	}
+	for _, sc := range c.scenarios {
+		sc.mu.Lock()
+		s.scenarioStates[sc] = scenarioSnapshot{state: sc.state, transitions: sc.transitions}
+		sc.mu.Unlock()
+	}
+	return s.clone()
+}
+
+// See the package level Restore().
+func (c *Connector) Restore(s *StubSnapshot) {
+	if s.c != c {
+		panic("testdb: can't restore a snapshot taken from another connector, or before the last Reset")
+	}
+
+	// Cloned again so the snapshot can be restored more than once
+	r := s.clone()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.queries = r.queries
+	c.queryFunc, c.execFunc = r.queryFunc, r.execFunc
+	c.beginFunc, c.commitFunc, c.rollbackFunc = r.beginFunc, r.commitFunc, r.rollbackFunc
+	c.pingFunc, c.resetFunc = r.pingFunc, r.resetFunc
+	c.queryHandlers, c.execHandlers = r.queryHandlers, r.execHandlers
+	c.savepointErrs = r.savepointErrs
+	c.interceptors = r.interceptors
+	c.unstubbedPolicy, c.unstubbedTB = r.unstubbedPolicy, r.unstubbedTB
+	c.checkUnused, c.noLastInsertId = r.checkUnused, r.noLastInsertId
+	c.passThroughArgs, c.argConverters, c.argConverter = r.passThroughArgs, r.argConverters, r.argConverter
+	c.badConns, c.beginBadConns = r.badConns, r.beginBadConns
+	c.queryGates, c.execGates, c.commitGate = r.queryGates, r.execGates, r.commitGate
+	c.scenarios = r.scenarios
+	for sc, state := range r.scenarioStates {
+		sc.mu.Lock()
+		sc.state, sc.transitions = state.state, state.transitions
+		sc.mu.Unlock()
+	}
+	c.queryFuncUsage, c.execFuncUsage = r.queryFuncUsage, r.execFuncUsage
+}
+
+// Copies the registry and settings so later calls don't change them. The stubs and gates themselves are shared, so hit counts and parked calls carry on across snapshots.
+func (s *StubSnapshot) clone() *StubSnapshot {
+	cp := *s
+
+	cp.queries = make(map[string][]*query, len(s.queries))
+	for hash, qs := range s.queries {
+		cp.queries[hash] = append([]*query(nil), qs...)
+	}
+	cp.queryHandlers = append([]queryHandler(nil), s.queryHandlers...)
+	cp.execHandlers = append([]execHandler(nil), s.execHandlers...)
+
+	cp.interceptors = append([]Interceptor(nil), s.interceptors...)
+	cp.scenarios = append([]*Scenario(nil), s.scenarios...)
+
+	cp.passThroughArgs = make(map[reflect.Type]bool, len(s.passThroughArgs))
+	for t, ok := range s.passThroughArgs {
+		cp.passThroughArgs[t] = ok
+	}
+	cp.argConverters = make(map[reflect.Type]func(interface{}) (driver.Value, error), len(s.argConverters))
+	for t, f := range s.argConverters {
+		cp.argConverters[t] = f
+	}
+	cp.badConns = make(map[string]int, len(s.badConns))
+	for hash, n := range s.badConns {
+		cp.badConns[hash] = n
+	}
+	cp.queryGates = make(map[string]*Gate, len(s.queryGates))
+	for hash, g := range s.queryGates {
+		cp.queryGates[hash] = g
+	}
+	cp.execGates = make(map[string]*Gate, len(s.execGates))
+	for hash, g := range s.execGates {
+		cp.execGates[hash] = g
+	}
+	cp.scenarioStates = make(map[*Scenario]scenarioSnapshot, len(s.scenarioStates))
+	for sc, state := range s.scenarioStates {
+		state.transitions = append([]transition(nil), state.transitions...)
+		cp.scenarioStates[sc] = state
+	}
+
+	if s.savepointErrs != nil {
+		cp.savepointErrs = make(map[SavepointOp]map[string]error, len(s.savepointErrs))
+		for op, errs := range s.savepointErrs {
+			cp.savepointErrs[op] = make(map[string]error, len(errs))
+			for name, err := range errs {
+				cp.savepointErrs[op][name] = err
+			}
+		}
+	}
+	return &cp
+}
@@ -0,0 +1,107 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT id FROM users", RowsFromCSVString([]string{"id"}, "1"))
+
+	db, _ := sql.Open("testdb", "")
+
+	for _, name := range []string{"first", "second"} {
+		t.Run(name, func(t *testing.T) {
+			defer Restore(Snapshot())
+
+			if _, err := db.Query("SELECT id FROM teams"); err == nil {
+				t.Fatal("stubs from an earlier subtest should have been dropped")
+			}
+
+			StubQuery("SELECT id FROM users", RowsFromCSVString([]string{"id"}, "2"))
+			StubQuery("SELECT id FROM teams", RowsFromCSVString([]string{"id"}, "3"))
+			SetExecFunc(func(query string) (driver.Result, error) {
+				return NewResult(0, nil, 1, nil), nil
+			})
+
+			var id int
+			db.QueryRow("SELECT id FROM users").Scan(&id)
+			if id != 2 {
+				t.Fatal("expected the subtest's stub to replace the parent's, got", id)
+			}
+		})
+	}
+
+	var id int
+	db.QueryRow("SELECT id FROM users").Scan(&id)
+	if id != 1 {
+		t.Fatal("expected the parent's stub to be restored, got", id)
+	}
+	if _, err := db.Exec("DELETE FROM users"); err == nil {
+		t.Fatal("expected the exec func to be dropped")
+	}
+}
+
+func TestRestoreAfterReset(t *testing.T) {
+	defer Reset()
+
+	s := Snapshot()
+	Reset()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected restoring a snapshot from before Reset to panic")
+		}
+	}()
+	Restore(s)
+}
+
+type passThroughID struct{ id int }
+
+func TestRestoreSettings(t *testing.T) {
+	defer Reset()
+
+	sc := NewScenario("signup")
+	sc.StubQuery(ScenarioStarted, "SELECT count(*) FROM users", RowsFromCSVString([]string{"count"}, "0"))
+
+	s := Snapshot()
+
+	intercepted := 0
+	AddInterceptor(Interceptor{
+		Before: func(call *Call) {
+			intercepted++
+		},
+	})
+	SetUnstubbedPolicy(UnstubbedEmpty)
+	PassThroughArgs(passThroughID{})
+	StubBadConn("SELECT id FROM users", 10)
+	GateQuery("SELECT id FROM teams")
+	sc.SetState("registered")
+	sc.Transition("registered", "DELETE FROM users", "deleted")
+	NewScenario("billing")
+
+	Restore(s)
+
+	db, _ := sql.Open("testdb", "")
+
+	if _, err := db.Query("SELECT id FROM users"); err == nil || err == driver.ErrBadConn {
+		t.Fatal("expected the bad connections to be dropped and the unstubbed policy restored, got", err)
+	}
+	if intercepted != 0 {
+		t.Fatal("expected the interceptor to be dropped")
+	}
+	if sc.State() != ScenarioStarted {
+		t.Fatal("expected the scenario state to be restored, got", sc.State())
+	}
+
+	c := d.conn.Connector
+	if len(c.queryGates) != 0 || len(c.passThroughArgs) != 0 {
+		t.Fatal("expected the gate and the pass through type to be dropped")
+	}
+	if len(c.scenarios) != 1 || len(sc.transitions) != 0 {
+		t.Fatal("expected the scenarios and transitions added since the snapshot to be dropped")
+	}
+}
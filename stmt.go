@@ -1,27 +1,39 @@
 package testdb
 
 import (
+	"context"
 	"database/sql/driver"
 )
 
 type stmt struct {
-	queryFunc func(args []driver.Value) (driver.Rows, error)
-	execFunc  func(args []driver.Value) (driver.Result, error)
+	tracked
+	numInput  int
+	queryFunc func(ctx context.Context, args []driver.NamedValue) (driver.Rows, error)
+	execFunc  func(ctx context.Context, args []driver.NamedValue) (driver.Result, error)
 }
 
 func (s *stmt) Close() error {
+	s.close()
 	return nil
 }
 
 func (s *stmt) NumInput() int {
-	// This prevents the sql package from validating the number of inputs
-	return -1
+	// -1 prevents the sql package from validating the number of inputs, unless placeholder counting is enabled
+	return s.numInput
 }
 
 func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
-	return s.execFunc(args)
+	return s.execFunc(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.execFunc(ctx, args)
 }
 
 func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
-	return s.queryFunc(args)
+	return s.queryFunc(context.Background(), namedValues(args))
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.queryFunc(ctx, args)
 }
@@ -0,0 +1,938 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A table held in memory. Simple single-table INSERT, SELECT, UPDATE and DELETE statements naming it are run against its rows instead of the stubs, see CreateTable().
+type Table struct {
+	mu      sync.Mutex
+	name    string
+	columns []string
+	rows    [][]driver.Value
+	seq     *Sequence
+	// Column filled from seq when an INSERT leaves it out or sets it to NULL, -1 if there isn't one
+	autoIncrement int
+}
+
+// Returns the table's name.
+func (t *Table) Name() string {
+	return t.name
+}
+
+// Returns the table's columns.
+func (t *Table) Columns() []string {
+	return append([]string(nil), t.columns...)
+}
+
+// Returns a copy of the rows currently in the table, in insertion order.
+func (t *Table) Rows() [][]driver.Value {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rows := make([][]driver.Value, len(t.rows))
+	for i, row := range t.rows {
+		rows[i] = append([]driver.Value(nil), row...)
+	}
+	return rows
+}
+
+// Returns the sequence the table takes LastInsertId values from. Without an auto-increment column every inserted row takes the next value, like a rowid.
+func (t *Table) Sequence() *Sequence {
+	return t.seq
+}
+
+// Fills column from the table's sequence when an INSERT leaves it out or sets it to NULL. The sequence carries on after the largest value already in the column.
+func (t *Table) AutoIncrement(column string) *Table {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.autoIncrement = t.column(column)
+	if t.autoIncrement < 0 {
+		panic(fmt.Sprintf("testdb: column %q does not exist in table %q", column, t.name))
+	}
+
+	t.seq.mu.Lock()
+	defer t.seq.mu.Unlock()
+	t.seq.start, t.seq.next = 1, 1
+	for _, row := range t.rows {
+		if id, ok := row[t.autoIncrement].(int64); ok && id >= t.seq.next {
+			t.seq.start, t.seq.next = id+1, id+1
+		}
+	}
+	return t
+}
+
+// Returns the index of column, or -1 if the table doesn't have it.
+func (t *Table) column(name string) int {
+	for i, col := range t.columns {
+		if strings.EqualFold(col, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Creates a table held in memory with the given columns and seed rows, replacing any table with the same name. Statements against it the engine can't parse fall back to the stubs. Table and column names are case insensitive.
+func CreateTable(name string, columns []string, rows ...[]interface{}) *Table {
+	return d.conn.CreateTable(name, columns, rows...)
+}
+
+// See the package level CreateTable().
+func (c *Connector) CreateTable(name string, columns []string, rows ...[]interface{}) *Table {
+	t := &Table{name: name, columns: append([]string(nil), columns...), autoIncrement: -1}
+	for _, row := range rows {
+		values := make([]driver.Value, len(columns))
+		for i := range values {
+			if i < len(row) {
+				v, err := driver.DefaultParameterConverter.ConvertValue(row[i])
+				if err != nil {
+					panic(fmt.Sprintf("testdb: can't seed table %q: %v", name, err))
+				}
+				values[i] = v
+			}
+		}
+		t.rows = append(t.rows, values)
+	}
+	t.seq = c.NewSequence(int64(len(t.rows)) + 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables[strings.ToLower(name)] = t
+	return t
+}
+
+// Returns the table named by the statement if the engine can run it.
+func (c *Connector) table(query string) (*Table, *statement) {
+	c.mu.Lock()
+	empty := len(c.tables) == 0
+	c.mu.Unlock()
+	if empty {
+		return nil, nil
+	}
+
+	s, ok := parseStatement(query)
+	if !ok {
+		return nil, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t := c.tables[strings.ToLower(s.table)]; t != nil {
+		return t, s
+	}
+	return nil, nil
+}
+
+// Reports whether query is a statement the engine can run against one of the in-memory tables.
+func (c *Connector) handlesTable(query string) bool {
+	t, _ := c.table(query)
+	return t != nil
+}
+
+// Runs query against the in-memory tables, ok is false if the engine can't handle it.
+func (c *Connector) runTable(query string, args []driver.NamedValue) (rs driver.Rows, res driver.Result, ok bool, err error) {
+	t, s := c.table(query)
+	if t == nil {
+		return nil, nil, false, nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch s.kind {
+	case "select":
+		rs, err = s.query(t, args)
+		res = NewResult(0, nil, 0, nil)
+	case "insert":
+		res, err = s.insert(t, args)
+	case "update":
+		res, err = s.update(t, args)
+	case "delete":
+		res, err = s.delete(t, args)
+	}
+	if rs == nil && err == nil {
+		rs = &rows{}
+	}
+	return rs, res, true, err
+}
+
+// A value in a statement, either a literal or a placeholder bound when the statement runs.
+type expr struct {
+	value   driver.Value
+	ordinal int
+	name    string
+}
+
+func (e expr) bind(args []driver.NamedValue) (driver.Value, error) {
+	switch {
+	case e.name != "":
+		for _, arg := range args {
+			if strings.EqualFold(arg.Name, e.name) {
+				return arg.Value, nil
+			}
+		}
+		return nil, fmt.Errorf("testdb: no argument named %q", e.name)
+	case e.ordinal > 0:
+		for _, arg := range args {
+			if arg.Ordinal == e.ordinal {
+				return arg.Value, nil
+			}
+		}
+		return nil, fmt.Errorf("testdb: missing argument %d", e.ordinal)
+	}
+	return e.value, nil
+}
+
+// A WHERE clause, either a comparison or AND, OR or NOT of other conditions.
+type condition struct {
+	op          string
+	left, right *condition
+	column      string
+	values      []expr
+}
+
+// Evaluates the condition against a row, known is false when the result is NULL.
+func (cd *condition) eval(t *Table, row []driver.Value, args []driver.NamedValue) (result, known bool, err error) {
+	switch cd.op {
+	case "and", "or":
+		l, lknown, err := cd.left.eval(t, row, args)
+		if err != nil {
+			return false, false, err
+		}
+		r, rknown, err := cd.right.eval(t, row, args)
+		if err != nil {
+			return false, false, err
+		}
+		if cd.op == "and" {
+			if lknown && !l || rknown && !r {
+				return false, true, nil
+			}
+			return true, lknown && rknown, nil
+		}
+		if lknown && l || rknown && r {
+			return true, true, nil
+		}
+		return false, lknown && rknown, nil
+	case "not":
+		v, known, err := cd.left.eval(t, row, args)
+		return !v, known, err
+	}
+
+	i := t.column(cd.column)
+	if i < 0 {
+		return false, false, fmt.Errorf("testdb: column %q does not exist in table %q", cd.column, t.name)
+	}
+	v := row[i]
+
+	switch cd.op {
+	case "is null":
+		return v == nil, true, nil
+	case "is not null":
+		return v != nil, true, nil
+	case "in":
+		known = true
+		for _, e := range cd.values {
+			arg, err := e.bind(args)
+			if err != nil {
+				return false, false, err
+			}
+			cmp, ok := compareValues(v, arg)
+			if ok && cmp == 0 {
+				return true, true, nil
+			}
+			known = known && ok
+		}
+		return false, known, nil
+	}
+
+	arg, err := cd.values[0].bind(args)
+	if err != nil {
+		return false, false, err
+	}
+	cmp, ok := compareValues(v, arg)
+	if !ok {
+		return false, false, nil
+	}
+	switch cd.op {
+	case "=":
+		return cmp == 0, true, nil
+	case "<>":
+		return cmp != 0, true, nil
+	case "<":
+		return cmp < 0, true, nil
+	case "<=":
+		return cmp <= 0, true, nil
+	case ">":
+		return cmp > 0, true, nil
+	default:
+		return cmp >= 0, true, nil
+	}
+}
+
+// Compares two values, ok is false if either is NULL or they can't be compared.
+func compareValues(a, b driver.Value) (int, bool) {
+	if a == nil || b == nil {
+		return 0, false
+	}
+	if x, ok := a.([]byte); ok {
+		a = string(x)
+	}
+	if x, ok := b.([]byte); ok {
+		b = string(x)
+	}
+
+	switch x := a.(type) {
+	case int64:
+		switch y := b.(type) {
+		case int64:
+			switch {
+			case x < y:
+				return -1, true
+			case x > y:
+				return 1, true
+			}
+			return 0, true
+		case float64:
+			return compareFloats(float64(x), y), true
+		}
+	case float64:
+		switch y := b.(type) {
+		case int64:
+			return compareFloats(x, float64(y)), true
+		case float64:
+			return compareFloats(x, y), true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			} else if !x {
+				return -1, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			switch {
+			case x.Before(y):
+				return -1, true
+			case x.After(y):
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+type assignment struct {
+	column string
+	value  expr
+}
+
+type ordering struct {
+	column string
+	desc   bool
+}
+
+// A parsed statement the engine can run.
+type statement struct {
+	kind    string
+	table   string
+	columns []string
+	values  [][]expr
+	set     []assignment
+	where   *condition
+	orderBy []ordering
+	limit   *expr
+	offset  *expr
+	count   bool
+}
+
+// Returns the rows of the table matching the WHERE clause, in table order.
+func (s *statement) matching(t *Table, args []driver.NamedValue) ([]int, error) {
+	var matched []int
+	for i, row := range t.rows {
+		if s.where != nil {
+			ok, known, err := s.where.eval(t, row, args)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || !known {
+				continue
+			}
+		}
+		matched = append(matched, i)
+	}
+	return matched, nil
+}
+
+func (s *statement) query(t *Table, args []driver.NamedValue) (driver.Rows, error) {
+	matched, err := s.matching(t, args)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.count {
+		return &rows{columns: []string{"count"}, rows: [][]driver.Value{{int64(len(matched))}}}, nil
+	}
+
+	for _, o := range s.orderBy {
+		if t.column(o.column) < 0 {
+			return nil, fmt.Errorf("testdb: column %q does not exist in table %q", o.column, t.name)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		for _, o := range s.orderBy {
+			col := t.column(o.column)
+			a, b := t.rows[matched[i]][col], t.rows[matched[j]][col]
+			cmp, _ := compareValues(a, b)
+			// NULLs sort first
+			if a == nil && b != nil {
+				cmp = -1
+			} else if a != nil && b == nil {
+				cmp = 1
+			}
+			if cmp != 0 {
+				return cmp < 0 != o.desc
+			}
+		}
+		return false
+	})
+
+	if s.offset != nil {
+		n, err := s.bindInt(*s.offset, args)
+		if err != nil {
+			return nil, err
+		}
+		if n > len(matched) {
+			n = len(matched)
+		}
+		matched = matched[n:]
+	}
+	if s.limit != nil {
+		n, err := s.bindInt(*s.limit, args)
+		if err != nil {
+			return nil, err
+		}
+		if n < len(matched) {
+			matched = matched[:n]
+		}
+	}
+
+	columns := s.columns
+	if columns == nil {
+		columns = t.columns
+	}
+	indexes := make([]int, len(columns))
+	for i, col := range columns {
+		if indexes[i] = t.column(col); indexes[i] < 0 {
+			return nil, fmt.Errorf("testdb: column %q does not exist in table %q", col, t.name)
+		}
+	}
+
+	result := &rows{columns: make([]string, len(indexes))}
+	for i, col := range indexes {
+		result.columns[i] = t.columns[col]
+	}
+	for _, r := range matched {
+		row := make([]driver.Value, len(indexes))
+		for i, col := range indexes {
+			row[i] = t.rows[r][col]
+		}
+		result.rows = append(result.rows, row)
+	}
+	return result, nil
+}
+
+func (s *statement) bindInt(e expr, args []driver.NamedValue) (int, error) {
+	v, err := e.bind(args)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.(int64)
+	if !ok || n < 0 {
+		return 0, fmt.Errorf("testdb: LIMIT and OFFSET must be non-negative integers, got %v", v)
+	}
+	return int(n), nil
+}
+
+func (s *statement) insert(t *Table, args []driver.NamedValue) (driver.Result, error) {
+	columns := s.columns
+	if columns == nil {
+		columns = t.columns
+	}
+	indexes := make([]int, len(columns))
+	for i, col := range columns {
+		if indexes[i] = t.column(col); indexes[i] < 0 {
+			return nil, fmt.Errorf("testdb: column %q does not exist in table %q", col, t.name)
+		}
+	}
+
+	var inserted [][]driver.Value
+	var lastId int64
+	for _, values := range s.values {
+		if len(values) != len(columns) {
+			return nil, fmt.Errorf("testdb: INSERT has %d values but %d columns", len(values), len(columns))
+		}
+		row := make([]driver.Value, len(t.columns))
+		for i, e := range values {
+			v, err := e.bind(args)
+			if err != nil {
+				return nil, err
+			}
+			row[indexes[i]] = v
+		}
+		inserted = append(inserted, row)
+	}
+
+	for _, row := range inserted {
+		switch {
+		case t.autoIncrement < 0:
+			lastId = t.seq.Next()
+		case row[t.autoIncrement] == nil:
+			lastId = t.seq.Next()
+			row[t.autoIncrement] = lastId
+		default:
+			if id, ok := row[t.autoIncrement].(int64); ok {
+				t.seq.skipPast(id)
+				lastId = id
+			}
+		}
+	}
+
+	t.rows = append(t.rows, inserted...)
+	return NewResult(lastId, nil, int64(len(inserted)), nil), nil
+}
+
+func (s *statement) update(t *Table, args []driver.NamedValue) (driver.Result, error) {
+	indexes := make([]int, len(s.set))
+	values := make([]driver.Value, len(s.set))
+	for i, a := range s.set {
+		if indexes[i] = t.column(a.column); indexes[i] < 0 {
+			return nil, fmt.Errorf("testdb: column %q does not exist in table %q", a.column, t.name)
+		}
+		v, err := a.value.bind(args)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+
+	matched, err := s.matching(t, args)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range matched {
+		row := append([]driver.Value(nil), t.rows[r]...)
+		for i, col := range indexes {
+			row[col] = values[i]
+		}
+		t.rows[r] = row
+	}
+	return NewResult(0, nil, int64(len(matched)), nil), nil
+}
+
+func (s *statement) delete(t *Table, args []driver.NamedValue) (driver.Result, error) {
+	matched, err := s.matching(t, args)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := make([][]driver.Value, 0, len(t.rows)-len(matched))
+	for i, row := range t.rows {
+		if len(matched) > 0 && matched[0] == i {
+			matched = matched[1:]
+			continue
+		}
+		kept = append(kept, row)
+	}
+	affected := len(t.rows) - len(kept)
+	t.rows = kept
+	return NewResult(0, nil, int64(affected), nil), nil
+}
+
+var keywords = map[string]bool{
+	"select": true, "from": true, "where": true, "insert": true, "into": true, "values": true,
+	"update": true, "set": true, "delete": true, "order": true, "by": true, "limit": true,
+	"offset": true, "and": true, "or": true, "not": true, "is": true, "null": true, "in": true,
+	"asc": true, "desc": true,
+}
+
+// Parses statements of the simple forms the engine supports, anything else isn't ok.
+type parser struct {
+	tokens  []string
+	pos     int
+	ordinal int
+}
+
+func parseStatement(query string) (s *statement, ok bool) {
+	p := &parser{tokens: tokenize(query)}
+
+	switch p.next() {
+	case "select":
+		s, ok = p.parseSelect()
+	case "insert":
+		s, ok = p.parseInsert()
+	case "update":
+		s, ok = p.parseUpdate()
+	case "delete":
+		s, ok = p.parseDelete()
+	}
+	p.accept(";")
+	if !ok || p.pos != len(p.tokens) {
+		return nil, false
+	}
+	return s, true
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) next() string {
+	tok := p.peek()
+	if tok != "" {
+		p.pos++
+	}
+	return tok
+}
+
+// Consumes the tokens if they come next.
+func (p *parser) accept(tokens ...string) bool {
+	if p.pos+len(tokens) > len(p.tokens) {
+		return false
+	}
+	for i, tok := range tokens {
+		if p.tokens[p.pos+i] != tok {
+			return false
+		}
+	}
+	p.pos += len(tokens)
+	return true
+}
+
+// Parses a possibly quoted identifier, dropping any table qualifier.
+func (p *parser) ident() (string, bool) {
+	tok := p.peek()
+	switch {
+	case tok == "":
+		return "", false
+	case tok[0] == '"' || tok[0] == '`':
+		p.pos++
+		return strings.Trim(tok, "\"`"), true
+	case !isIdentChar(tok[0]) || isDigit(tok[0]) || keywords[tok]:
+		return "", false
+	}
+	p.pos++
+	if i := strings.LastIndexByte(tok, '.'); i >= 0 {
+		tok = tok[i+1:]
+	}
+	return tok, true
+}
+
+func (p *parser) identList() ([]string, bool) {
+	var names []string
+	for {
+		name, ok := p.ident()
+		if !ok {
+			return nil, false
+		}
+		names = append(names, name)
+		if !p.accept(",") {
+			return names, true
+		}
+	}
+}
+
+// Parses a literal or a placeholder.
+func (p *parser) value() (expr, bool) {
+	tok := p.next()
+	switch {
+	case tok == "?":
+		p.ordinal++
+		return expr{ordinal: p.ordinal}, true
+	case tok == "$":
+		n, err := strconv.Atoi(p.next())
+		return expr{ordinal: n}, err == nil && n > 0
+	case tok == ":" || tok == "@":
+		name, ok := p.ident()
+		return expr{name: name}, ok
+	case tok == "null":
+		return expr{}, true
+	case tok == "true" || tok == "false":
+		return expr{value: tok == "true"}, true
+	case tok == "-":
+		e, ok := p.value()
+		switch v := e.value.(type) {
+		case int64:
+			return expr{value: -v}, ok
+		case float64:
+			return expr{value: -v}, ok
+		}
+		return expr{}, false
+	case strings.HasPrefix(tok, "'"):
+		return expr{value: strings.ReplaceAll(strings.TrimSuffix(tok[1:], "'"), "''", "'")}, true
+	case tok != "" && isDigit(tok[0]):
+		if n, err := strconv.ParseInt(tok, 10, 64); err == nil {
+			return expr{value: n}, true
+		}
+		f, err := strconv.ParseFloat(tok, 64)
+		return expr{value: f}, err == nil
+	}
+	return expr{}, false
+}
+
+func (p *parser) valueList() ([]expr, bool) {
+	if !p.accept("(") {
+		return nil, false
+	}
+	var values []expr
+	for {
+		e, ok := p.value()
+		if !ok {
+			return nil, false
+		}
+		values = append(values, e)
+		if p.accept(")") {
+			return values, true
+		}
+		if !p.accept(",") {
+			return nil, false
+		}
+	}
+}
+
+func (p *parser) parseSelect() (*statement, bool) {
+	s := &statement{kind: "select"}
+
+	switch {
+	case p.accept("*"):
+	case p.accept("count", "(", "*", ")"):
+		s.count = true
+	default:
+		columns, ok := p.identList()
+		if !ok {
+			return nil, false
+		}
+		s.columns = columns
+	}
+
+	var ok bool
+	if !p.accept("from") {
+		return nil, false
+	}
+	if s.table, ok = p.ident(); !ok {
+		return nil, false
+	}
+	if s.where, ok = p.parseWhere(); !ok {
+		return nil, false
+	}
+
+	if p.accept("order", "by") {
+		for {
+			var o ordering
+			if o.column, ok = p.ident(); !ok {
+				return nil, false
+			}
+			if p.accept("desc") {
+				o.desc = true
+			} else {
+				p.accept("asc")
+			}
+			s.orderBy = append(s.orderBy, o)
+			if !p.accept(",") {
+				break
+			}
+		}
+	}
+	if p.accept("limit") {
+		e, ok := p.value()
+		if !ok {
+			return nil, false
+		}
+		s.limit = &e
+	}
+	if p.accept("offset") {
+		e, ok := p.value()
+		if !ok {
+			return nil, false
+		}
+		s.offset = &e
+	}
+	return s, true
+}
+
+func (p *parser) parseInsert() (*statement, bool) {
+	s := &statement{kind: "insert"}
+
+	var ok bool
+	if !p.accept("into") {
+		return nil, false
+	}
+	if s.table, ok = p.ident(); !ok {
+		return nil, false
+	}
+	if p.accept("(") {
+		if s.columns, ok = p.identList(); !ok || !p.accept(")") {
+			return nil, false
+		}
+	}
+	if !p.accept("values") {
+		return nil, false
+	}
+	for {
+		values, ok := p.valueList()
+		if !ok {
+			return nil, false
+		}
+		s.values = append(s.values, values)
+		if !p.accept(",") {
+			return s, true
+		}
+	}
+}
+
+func (p *parser) parseUpdate() (*statement, bool) {
+	s := &statement{kind: "update"}
+
+	var ok bool
+	if s.table, ok = p.ident(); !ok || !p.accept("set") {
+		return nil, false
+	}
+	for {
+		var a assignment
+		if a.column, ok = p.ident(); !ok || !p.accept("=") {
+			return nil, false
+		}
+		if a.value, ok = p.value(); !ok {
+			return nil, false
+		}
+		s.set = append(s.set, a)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if s.where, ok = p.parseWhere(); !ok {
+		return nil, false
+	}
+	return s, true
+}
+
+func (p *parser) parseDelete() (*statement, bool) {
+	s := &statement{kind: "delete"}
+
+	var ok bool
+	if !p.accept("from") {
+		return nil, false
+	}
+	if s.table, ok = p.ident(); !ok {
+		return nil, false
+	}
+	if s.where, ok = p.parseWhere(); !ok {
+		return nil, false
+	}
+	return s, true
+}
+
+// Parses an optional WHERE clause, a missing clause returns a nil condition.
+func (p *parser) parseWhere() (*condition, bool) {
+	if !p.accept("where") {
+		return nil, true
+	}
+	return p.parseOr()
+}
+
+func (p *parser) parseOr() (*condition, bool) {
+	left, ok := p.parseAnd()
+	for ok && p.accept("or") {
+		var right *condition
+		if right, ok = p.parseAnd(); ok {
+			left = &condition{op: "or", left: left, right: right}
+		}
+	}
+	return left, ok
+}
+
+func (p *parser) parseAnd() (*condition, bool) {
+	left, ok := p.parseCondition()
+	for ok && p.accept("and") {
+		var right *condition
+		if right, ok = p.parseCondition(); ok {
+			left = &condition{op: "and", left: left, right: right}
+		}
+	}
+	return left, ok
+}
+
+func (p *parser) parseCondition() (*condition, bool) {
+	if p.accept("(") {
+		cd, ok := p.parseOr()
+		return cd, ok && p.accept(")")
+	}
+	if p.accept("not") {
+		cd, ok := p.parseCondition()
+		return &condition{op: "not", left: cd}, ok
+	}
+
+	column, ok := p.ident()
+	if !ok {
+		return nil, false
+	}
+	cd := &condition{column: column}
+
+	switch {
+	case p.accept("is", "null"):
+		cd.op = "is null"
+		return cd, true
+	case p.accept("is", "not", "null"):
+		cd.op = "is not null"
+		return cd, true
+	case p.accept("in"):
+		cd.op = "in"
+		cd.values, ok = p.valueList()
+		return cd, ok
+	case p.accept("not", "in"):
+		cd.op = "in"
+		cd.values, ok = p.valueList()
+		return &condition{op: "not", left: cd}, ok
+	case p.accept("<", ">"), p.accept("!", "="):
+		cd.op = "<>"
+	case p.accept("<", "="):
+		cd.op = "<="
+	case p.accept(">", "="):
+		cd.op = ">="
+	case p.accept("="), p.accept("<"), p.accept(">"):
+		cd.op = p.tokens[p.pos-1]
+	default:
+		return nil, false
+	}
+
+	e, ok := p.value()
+	cd.values = []expr{e}
+	return cd, ok
+}
@@ -0,0 +1,108 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"testing"
+)
+
+func TestTableInsertAndSelect(t *testing.T) {
+	defer Reset()
+
+	CreateTable("users", []string{"id", "name", "age"},
+		[]interface{}{1, "tim", 20},
+		[]interface{}{2, "joe", 25},
+	)
+
+	db, _ := sql.Open("testdb", "")
+
+	res, err := db.Exec("INSERT INTO users (id, name, age) VALUES (?, ?, ?), (?, ?, ?)", 3, "bob", 30, 4, "ann", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("expected 2 rows affected, got %d", n)
+	}
+	if id, _ := res.LastInsertId(); id != 4 {
+		t.Fatalf("expected last insert id 4, got %d", id)
+	}
+
+	rows, err := db.Query("SELECT name FROM users WHERE age >= $1 OR age IS NULL ORDER BY name DESC LIMIT 2 OFFSET 1", 25)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		names = append(names, name)
+	}
+	if !reflect.DeepEqual(names, []string{"bob", "ann"}) {
+		t.Fatal("unexpected rows", names)
+	}
+}
+
+func TestTableUpdateAndDelete(t *testing.T) {
+	defer Reset()
+
+	users := CreateTable("users", []string{"id", "name"},
+		[]interface{}{1, "tim"},
+		[]interface{}{2, "joe"},
+		[]interface{}{3, "bob"},
+	)
+
+	db, _ := sql.Open("testdb", "")
+
+	res, err := db.Exec("UPDATE users SET name = @name WHERE id IN (1, 3)", sql.Named("name", "sam"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 2 {
+		t.Fatalf("expected 2 rows affected, got %d", n)
+	}
+
+	res, err = db.Exec("DELETE FROM users WHERE name = 'joe'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatalf("expected 1 row affected, got %d", n)
+	}
+
+	expected := [][]driver.Value{{int64(1), "sam"}, {int64(3), "sam"}}
+	if !reflect.DeepEqual(users.Rows(), expected) {
+		t.Fatal("unexpected table contents", users.Rows())
+	}
+
+	var count int
+	if err := db.QueryRow("select count(*) from users where not (id = 1)").Scan(&count); err != nil || count != 1 {
+		t.Fatal("expected a count of 1, got", count, err)
+	}
+}
+
+func TestTableFallsBackToStubs(t *testing.T) {
+	defer Reset()
+
+	CreateTable("users", []string{"id", "name"})
+	StubQuery("SELECT u.name FROM users u JOIN teams t ON t.id = u.team_id", RowsFromCSVString([]string{"name"}, "tim"))
+
+	db, _ := sql.Open("testdb", "")
+
+	var name string
+	if err := db.QueryRow("SELECT u.name FROM users u JOIN teams t ON t.id = u.team_id").Scan(&name); err != nil || name != "tim" {
+		t.Fatal("expected the stub to be used, got", name, err)
+	}
+
+	if _, err := db.Exec("INSERT INTO users (id, email) VALUES (1, 'tim@example.com')"); err == nil {
+		t.Fatal("expected an error for an unknown column")
+	}
+
+	stmt, err := db.Prepare("SELECT name FROM users WHERE id = ?")
+	if err != nil {
+		t.Fatal("statements the engine handles should be preparable", err)
+	}
+	stmt.Close()
+}
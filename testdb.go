@@ -1,13 +1,16 @@
 package testdb
 
 import (
+	"context"
 	"crypto/sha1"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/csv"
 	"io"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -22,17 +25,30 @@ type testDriver struct {
 	openFunc          func(dsn string) (driver.Conn, error)
 	conn              *conn
 	enableTimeParsing bool
+	countPlaceholders bool
+
+	connectorsMu sync.Mutex
+	connectors   map[string]*Connector
 }
 
 type query struct {
+	sql    string
+	args   []driver.NamedValue // nil matches any arguments
 	rows   driver.Rows
 	result *Result
 	err    error
+
+	// Only used while the scenario is in state, when set
+	scenario *Scenario
+	state    string
+
+	usage *StubUsage
 }
 
 func newDriver() *testDriver {
 	return &testDriver{
-		conn: newConn(),
+		conn:       newConn(),
+		connectors: make(map[string]*Connector),
 	}
 }
 
@@ -40,6 +56,16 @@ func EnableTimeParsing(flag bool) {
 	d.enableTimeParsing = flag
 }
 
+// When enabled, queries are parsed for ?, $N, :name and @name placeholders (skipping string literals and comments) and calls with the wrong number of arguments fail just like they would against a real database.
+func EnablePlaceholderCounting(flag bool) {
+	d.countPlaceholders = flag
+}
+
+// Opens a new connection. The DSN is a name, which picks the Connector registered with DSN() or RegisterConnector() if there is one, optionally followed by options to configure the connection:
+//
+//	replica?strict=true&latency=5ms&normalize=tokens&placeholders=dollar
+//
+// strict turns on placeholder counting, latency delays every call, normalize picks how queries are compared to stubs (whitespace, tokens or exact) and placeholders picks which placeholder syntax is counted (any, question, dollar, named or at) and lastinsertid=false makes LastInsertId unsupported. Unknown options are an error.
 func (d *testDriver) Open(dsn string) (driver.Conn, error) {
 	if d.openFunc != nil {
 		conn, err := d.openFunc(dsn)
@@ -50,7 +76,96 @@ func (d *testDriver) Open(dsn string) (driver.Conn, error) {
 		d.conn = newConn()
 	}
 
-	return d.conn, nil
+	name, options := splitDSN(dsn)
+
+	c := d.connector(name)
+	if c == nil {
+		c = d.conn.Connector
+	}
+
+	cfg := c.config
+	if err := cfg.apply(options); err != nil {
+		return nil, err
+	}
+
+	cn, err := c.interceptOpen(name, cfg)
+	if err != nil {
+		return nil, err
+	}
+	cn.(*conn).routed = true
+	cn.(*conn).route = name
+	return cn, nil
+}
+
+// Implements driver.DriverContext. The returned driver.Connector calls Open() each time it connects, so connectors registered after sql.Open() are still picked up.
+func (d *testDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	return dsnConnector{dsn: dsn}, nil
+}
+
+type dsnConnector struct {
+	dsn string
+}
+
+func (dc dsnConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return d.Open(dc.dsn)
+}
+
+func (dsnConnector) Driver() driver.Driver {
+	return d
+}
+
+// Returns the Connector registered for dsn, or nil.
+func (d *testDriver) connector(dsn string) *Connector {
+	d.connectorsMu.Lock()
+	defer d.connectorsMu.Unlock()
+	return d.connectors[dsn]
+}
+
+// Routes sql.Open("testdb", name) to the supplied Connector, so code that only takes a DSN can be pointed at its own stubs. Options can follow the name as query parameters, see Open().
+func RegisterConnector(name string, c *Connector) {
+	d.connectorsMu.Lock()
+	defer d.connectorsMu.Unlock()
+	d.connectors[name] = c
+}
+
+// Returns the Connector behind sql.Open("testdb", name), creating and registering an empty one the first time. Each DSN gets its own independent stubs, so a "primary" and a "replica" handle can be stubbed separately. DSNs that have never been passed to DSN() or RegisterConnector() share the global driver.Conn.
+func DSN(name string) *Connector {
+	d.connectorsMu.Lock()
+	defer d.connectorsMu.Unlock()
+
+	c, ok := d.connectors[name]
+	if !ok {
+		c = NewConnector()
+		d.connectors[name] = c
+	}
+	return c
+}
+
+// Returns the DSN of the connection that served each call of query, in order. The global driver.Conn and every registered Connector are searched, query matching is case insensitive, and whitespace is also ignored.
+func ServedBy(query string) []string {
+	connectors := []*Connector{d.conn.Connector}
+	d.connectorsMu.Lock()
+	for _, c := range d.connectors {
+		connectors = append(connectors, c)
+	}
+	d.connectorsMu.Unlock()
+
+	var entries []QueryLogEntry
+	for _, c := range connectors {
+		entries = append(entries, c.QueryLog()...)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].seq < entries[j].seq
+	})
+
+	var dsns []string
+	hash := getQueryHash(query)
+	for _, e := range entries {
+		if getQueryHash(e.Query) == hash {
+			dsns = append(dsns, e.DSN)
+		}
+	}
+	return dsns
 }
 
 var whitespaceRegexp = regexp.MustCompile("\\s")
@@ -65,30 +180,39 @@ func getQueryHash(query string) string {
 	return string(h.Sum(nil))
 }
 
-// Set your own function to be executed when db.Query() is called. As with StubQuery() you can use the RowsFromCSVString() method to easily generate the driver.Rows, or you can return your own.
+// Set your own function to be executed when db.Query() is called. As with StubQuery() you can use the RowsFromCSVString() method to easily generate the driver.Rows, or you can return your own. Return ErrNotHandled to leave the query to the handlers and stubs.
 func SetQueryFunc(f func(query string) (result driver.Rows, err error)) {
-	SetQueryWithArgsFunc(func(query string, args []driver.Value) (result driver.Rows, err error) {
-		return f(query)
-	})
+	d.conn.SetQueryFunc(f)
 }
 
 // Set your own function to be executed when db.Query() is called. As with StubQuery() you can use the RowsFromCSVString() method to easily generate the driver.Rows, or you can return your own.
 func SetQueryWithArgsFunc(f func(query string, args []driver.Value) (result driver.Rows, err error)) {
-	d.conn.queryFunc = f
+	d.conn.SetQueryWithArgsFunc(f)
+}
+
+// Set your own function to be executed when db.Query() is called. Unlike SetQueryWithArgsFunc() the arguments keep the name and ordinal they were passed with, so sql.Named() arguments can be inspected.
+func SetQueryWithNamedArgsFunc(f func(query string, args []driver.NamedValue) (result driver.Rows, err error)) {
+	d.conn.SetQueryWithNamedArgsFunc(f)
 }
 
 // Stubs the global driver.Conn to return the supplied driver.Rows when db.Query() is called, query stubbing is case insensitive, and whitespace is also ignored.
 func StubQuery(q string, rows driver.Rows) {
-	d.conn.queries[getQueryHash(q)] = query{
-		rows: rows,
-	}
+	d.conn.StubQuery(q, rows)
+}
+
+// Stubs the global driver.Conn to return the supplied driver.Rows when db.Query() is called with matching arguments. Arguments are matched by position, or by name when passed as sql.Named(). A stub with matching arguments takes precedence over one stubbed with StubQuery().
+func StubQueryWithArgs(q string, rows driver.Rows, args ...interface{}) {
+	d.conn.StubQueryWithArgs(q, rows, args...)
 }
 
 // Stubs the global driver.Conn to return the supplied error when db.Query() is called, query stubbing is case insensitive, and whitespace is also ignored.
 func StubQueryError(q string, err error) {
-	d.conn.queries[getQueryHash(q)] = query{
-		err: err,
-	}
+	d.conn.StubQueryError(q, err)
+}
+
+// Stubs the global driver.Conn to return the supplied error when db.Query() is called with matching arguments.
+func StubQueryErrorWithArgs(q string, err error, args ...interface{}) {
+	d.conn.StubQueryErrorWithArgs(q, err, args...)
 }
 
 // Set your own function to be executed when db.Open() is called. You can either hand back a valid connection, or an error. Conn() can be used to grab the global Conn object containing stubbed queries.
@@ -96,77 +220,206 @@ func SetOpenFunc(f func(dsn string) (driver.Conn, error)) {
 	d.openFunc = f
 }
 
-// Set your own function to be executed when db.Exec is called. You can return an error or a Result object with the LastInsertId and RowsAffected
+// Set your own function to be executed when db.Ping() is called, or any time database/sql checks a connection is alive.
+func SetPingFunc(f func() error) {
+	d.conn.SetPingFunc(f)
+}
+
+// Stubs the global driver.Conn to return the supplied error when db.Ping() is called.
+func StubPingError(err error) {
+	d.conn.StubPingError(err)
+}
+
+// Set your own function to be executed when database/sql resets a connection before reusing it from the pool. Return driver.ErrBadConn to have the connection discarded.
+func SetResetSessionFunc(f func() error) {
+	d.conn.SetResetSessionFunc(f)
+}
+
+// Stubs the global driver.Conn to return the supplied error whenever database/sql resets a connection before reusing it.
+func StubResetSessionError(err error) {
+	d.conn.StubResetSessionError(err)
+}
+
+// Marks the open connection with the supplied ID as invalid, database/sql will discard it instead of returning it to the pool. IDs can be found in the QueryLog().
+func InvalidateConn(id int64) {
+	d.conn.InvalidateConn(id)
+}
+
+// Marks every open connection as invalid, simulating the database going away.
+func InvalidateConns() {
+	d.conn.InvalidateConns()
+}
+
+// Makes the first n attempts at running q return driver.ErrBadConn, after which it's handled as usual. Every Query, Exec and Prepare of q counts as an attempt, so database/sql's retries can be exercised. Query matching is case insensitive, and whitespace is also ignored.
+func StubBadConn(q string, n int) {
+	d.conn.StubBadConn(q, n)
+}
+
+// Makes the first n calls to db.Begin() return driver.ErrBadConn.
+func StubBeginBadConn(n int) {
+	d.conn.StubBeginBadConn(n)
+}
+
+// Returns the number of times q has been attempted with Query, Exec or Prepare since the last Reset, including attempts that failed.
+func Attempts(q string) int {
+	return d.conn.Attempts(q)
+}
+
+// Returns the number of times db.Begin() has been attempted since the last Reset.
+func BeginAttempts() int {
+	return d.conn.BeginAttempts()
+}
+
+// Returns the gate for q, creating it the first time. Queries matching q block at the gate until it releases them, or until their context is done. Query matching is case insensitive, and whitespace is also ignored.
+func GateQuery(q string) *Gate {
+	return d.conn.GateQuery(q)
+}
+
+// Returns the gate for q, creating it the first time. Exec calls matching q block at the gate until it releases them, or until their context is done.
+func GateExec(q string) *Gate {
+	return d.conn.GateExec(q)
+}
+
+// Returns the gate for commits, creating it the first time. Commits of transactions started after this call block at the gate until it releases them, or until the context passed to BeginTx is done.
+func GateCommit() *Gate {
+	return d.conn.GateCommit()
+}
+
+// Set your own function to be executed when db.Exec is called. You can return an error or a Result object with the LastInsertId and RowsAffected, or ErrNotHandled to leave the call to the handlers and stubs.
 func SetExecFunc(f func(query string) (driver.Result, error)) {
-	SetExecWithArgsFunc(func(query string, args []driver.Value) (driver.Result, error) {
-		return f(query)
-	})
+	d.conn.SetExecFunc(f)
 }
 
 // Set your own function to be executed when db.Exec is called. You can return an error or a Result object with the LastInsertId and RowsAffected
 func SetExecWithArgsFunc(f func(query string, args []driver.Value) (driver.Result, error)) {
-	d.conn.execFunc = f
+	d.conn.SetExecWithArgsFunc(f)
+}
+
+// Set your own function to be executed when db.Exec is called. Unlike SetExecWithArgsFunc() the arguments keep the name and ordinal they were passed with.
+func SetExecWithNamedArgsFunc(f func(query string, args []driver.NamedValue) (driver.Result, error)) {
+	d.conn.SetExecWithNamedArgsFunc(f)
 }
 
 // Stubs the global driver.Conn to return the supplied Result when db.Exec is called, query stubbing is case insensitive, and whitespace is also ignored.
 func StubExec(q string, r *Result) {
-	d.conn.queries[getQueryHash(q)] = query{
-		result: r,
-	}
+	d.conn.StubExec(q, r)
+}
+
+// Stubs the global driver.Conn to return the supplied Result when db.Exec is called with matching arguments. Arguments are matched by position, or by name when passed as sql.Named().
+func StubExecWithArgs(q string, r *Result, args ...interface{}) {
+	d.conn.StubExecWithArgs(q, r, args...)
 }
 
 // Stubs the global driver.Conn to return the supplied error when db.Exec() is called, query stubbing is case insensitive, and whitespace is also ignored.
 func StubExecError(q string, err error) {
-	StubQueryError(q, err)
+	d.conn.StubExecError(q, err)
+}
+
+// Stubs the global driver.Conn to return the supplied error when db.Exec() is called with matching arguments.
+func StubExecErrorWithArgs(q string, err error, args ...interface{}) {
+	d.conn.StubExecErrorWithArgs(q, err, args...)
 }
 
 // Set your own function to be executed when db.Begin() is called. You can either hand back a valid transaction, or an error. Conn() can be used to grab the global Conn object containing stubbed queries.
 func SetBeginFunc(f func() (driver.Tx, error)) {
-	d.conn.beginFunc = f
+	d.conn.SetBeginFunc(f)
 }
 
 // Stubs the global driver.Conn to return the supplied tx and error when db.Begin() is called.
 func StubBegin(tx driver.Tx, err error) {
-	SetBeginFunc(func() (driver.Tx, error) {
-		return tx, err
-	})
+	d.conn.StubBegin(tx, err)
 }
 
 // Set your own function to be executed when tx.Commit() is called on the default transcation. Conn() can be used to grab the global Conn object containing stubbed queries.
 func SetCommitFunc(f func() error) {
-	d.conn.commitFunc = f
+	d.conn.SetCommitFunc(f)
 }
 
 // Stubs the default transaction to return the supplied error when tx.Commit() is called.
 func StubCommitError(err error) {
-	SetCommitFunc(func() error {
-		return err
-	})
+	d.conn.StubCommitError(err)
 }
 
 // Set your own function to be executed when tx.Rollback() is called on the default transcation. Conn() can be used to grab the global Conn object containing stubbed queries.
 func SetRollbackFunc(f func() error) {
-	d.conn.rollbackFunc = f
+	d.conn.SetRollbackFunc(f)
 }
 
 // Stubs the default transaction to return the supplied error when tx.Rollback() is called.
 func StubRollbackError(err error) {
-	SetRollbackFunc(func() error {
-		return err
-	})
+	d.conn.StubRollbackError(err)
+}
+
+// Stubs every transaction started on the global driver.Conn to return the supplied error when SAVEPOINT name is executed.
+func StubSavepointError(name string, err error) {
+	d.conn.StubSavepointError(name, err)
+}
+
+// Stubs every transaction started on the global driver.Conn to return the supplied error when RELEASE SAVEPOINT name is executed.
+func StubReleaseSavepointError(name string, err error) {
+	d.conn.StubReleaseSavepointError(name, err)
+}
+
+// Stubs every transaction started on the global driver.Conn to return the supplied error when ROLLBACK TO SAVEPOINT name is executed.
+func StubRollbackToSavepointError(name string, err error) {
+	d.conn.StubRollbackToSavepointError(name, err)
+}
+
+// Returns the most recent transaction started on the global driver.Conn, or nil if Begin hasn't been called. Use it to inspect savepoints after running code that only exposes a *sql.Tx.
+func LastTx() *Tx {
+	return d.conn.LastTx()
+}
+
+// Arguments with the same type as any of the supplied examples are handed to query funcs and stubs exactly as the application passed them, instead of being converted by database/sql. Use it for types like pq.Array or UUID structs that would otherwise be turned into basic values.
+func PassThroughArgs(examples ...interface{}) {
+	d.conn.PassThroughArgs(examples...)
 }
 
-// Clears all stubbed queries, and replaced functions.
+// Registers a function to convert arguments with the same type as example before they are handed to query funcs and stubs.
+func RegisterArgConverter(example interface{}, f func(v interface{}) (driver.Value, error)) {
+	d.conn.RegisterArgConverter(example, f)
+}
+
+// Sets the converter used for every argument not handled by PassThroughArgs() or RegisterArgConverter(). Use it to mimic the conversion rules of a specific driver, if nil database/sql's default conversion is used.
+func SetArgConverter(c driver.ValueConverter) {
+	d.conn.SetArgConverter(c)
+}
+
+// Clears all stubbed queries, replaced functions and registered connectors.
 func Reset() {
 	d.conn = newConn()
 	d.openFunc = nil
+
+	d.connectorsMu.Lock()
+	d.connectors = make(map[string]*Connector)
+	d.connectorsMu.Unlock()
 }
 
-// Returns a pointer to the global conn object associated with this driver.
+// Returns a pointer to the global conn object associated with this driver. Every connection handed out by Open() shares its stubs.
 func Conn() driver.Conn {
 	return d.conn
 }
 
+// Caps the number of connections the driver will have open at once, Open() returns an error once the limit is reached. Use it with db.SetMaxOpenConns() to test pool starvation, zero removes the limit.
+func SetMaxConns(n int) {
+	d.conn.SetMaxConns(n)
+}
+
+// Makes every Open() after the first n return the supplied error.
+func FailOpensAfter(n int, err error) {
+	d.conn.FailOpensAfter(n, err)
+}
+
+// Returns the number of connections opened by the driver which haven't been closed yet.
+func OpenConns() int {
+	return d.conn.OpenConns()
+}
+
+// Returns the number of connections opened by the driver since the last Reset.
+func ConnsOpened() int {
+	return d.conn.ConnsOpened()
+}
+
 func RowsFromCSVString(columns []string, s string, c ...rune) driver.Rows {
 	r := strings.NewReader(strings.TrimSpace(s))
 	csvReader := csv.NewReader(r)
@@ -208,7 +461,6 @@ func RowsFromCSVString(columns []string, s string, c ...rune) driver.Rows {
 
 func RowsFromSlice(columns []string, data [][]driver.Value) driver.Rows {
 	return &rows{
-		closed:  false,
 		columns: columns,
 		rows:    data,
 		pos:     0,
@@ -373,6 +373,40 @@ func TestReset(t *testing.T) {
 	}
 }
 
+func TestResetWithPooledConns(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+	replica, _ := sql.Open("testdb", "replica")
+	defer db.Close()
+	defer replica.Close()
+
+	var n int
+	StubQuery("select 1", RowsFromCSVString([]string{"n"}, "1"))
+	DSN("replica").StubQuery("select 1", RowsFromCSVString([]string{"n"}, "1"))
+	if err := db.QueryRow("select 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if err := replica.QueryRow("select 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+
+	Reset()
+
+	StubQuery("select 2", RowsFromCSVString([]string{"n"}, "2"))
+	DSN("replica").StubQuery("select 2", RowsFromCSVString([]string{"n"}, "2"))
+	if err := db.QueryRow("select 2").Scan(&n); err != nil || n != 2 {
+		t.Fatal("connections pooled before Reset should see the new stubs", err)
+	}
+	if err := replica.QueryRow("select 2").Scan(&n); err != nil || n != 2 {
+		t.Fatal("connections pooled before Reset should see the new DSN stubs", err)
+	}
+
+	if len(QueryLog()) != 1 || len(DSN("replica").QueryLog()) != 1 {
+		t.Fatal("calls after Reset should be logged on the new connectors")
+	}
+}
+
 func TestStubQueryRow(t *testing.T) {
 	defer Reset()
 
@@ -1,22 +1,175 @@
 package testdb
 
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+type TxState int
+
+const (
+	TxOpen TxState = iota
+	TxCommitted
+	TxRolledBack
+	TxFailed
+)
+
+func (s TxState) String() string {
+	switch s {
+	case TxOpen:
+		return "open"
+	case TxCommitted:
+		return "committed"
+	case TxRolledBack:
+		return "rolled back"
+	case TxFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+type SavepointOp int
+
+const (
+	SavepointCreate SavepointOp = iota
+	SavepointRelease
+	SavepointRollback
+)
+
+func (op SavepointOp) String() string {
+	switch op {
+	case SavepointCreate:
+		return "SAVEPOINT"
+	case SavepointRelease:
+		return "RELEASE SAVEPOINT"
+	case SavepointRollback:
+		return "ROLLBACK TO SAVEPOINT"
+	}
+	return "unknown"
+}
+
+// A single savepoint statement executed inside a transaction, along with the error it returned (if any).
+type SavepointEvent struct {
+	Op   SavepointOp
+	Name string
+	Err  error
+}
+
 type Tx struct {
 	commitFunc   func() error
 	rollbackFunc func() error
+
+	mu        sync.Mutex
+	state     TxState
+	finishes  []string
+	createdAt string
+
+	savepoints    []string
+	history       []SavepointEvent
+	savepointErrs map[SavepointOp]map[string]error
+
+	// Context passed to BeginTx, a commit blocked by a gate gives up when it's done
+	ctx        context.Context
+	commitGate *Gate
+	// Connection the transaction was begun on, whose interceptors see its commit and rollback
+	conn *conn
+}
+
+var (
+	savepointRegexp           = regexp.MustCompile(`(?i)^\s*savepoint\s+("[^"]+"|[\w$]+)\s*;?\s*$`)
+	releaseSavepointRegexp    = regexp.MustCompile(`(?i)^\s*release\s+(?:savepoint\s+)?("[^"]+"|[\w$]+)\s*;?\s*$`)
+	rollbackToSavepointRegexp = regexp.MustCompile(`(?i)^\s*rollback\s+(?:work\s+|transaction\s+)?to\s+(?:savepoint\s+)?("[^"]+"|[\w$]+)\s*;?\s*$`)
+)
+
+// Parses a SAVEPOINT, RELEASE [SAVEPOINT] or ROLLBACK TO [SAVEPOINT] statement.
+func parseSavepoint(query string) (SavepointOp, string, bool) {
+	for op, re := range []*regexp.Regexp{savepointRegexp, releaseSavepointRegexp, rollbackToSavepointRegexp} {
+		if m := re.FindStringSubmatch(query); m != nil {
+			return SavepointOp(op), strings.Trim(m[1], `"`), true
+		}
+	}
+	return 0, "", false
 }
 
 func (t *Tx) Commit() error {
+	return t.intercept(OpCommit, t.commit)
+}
+
+func (t *Tx) commit() error {
+	if t.commitGate != nil {
+		ctx := t.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if err := t.commitGate.pass(ctx); err != nil {
+			t.finish("commit", TxFailed, err)
+			return err
+		}
+	}
+
+	var err error
 	if t.commitFunc != nil {
-		return t.commitFunc()
+		err = t.commitFunc()
 	}
-	return nil
+	t.finish("commit", TxCommitted, err)
+	return err
 }
 
 func (t *Tx) Rollback() error {
+	return t.intercept(OpRollback, t.rollback)
+}
+
+func (t *Tx) rollback() error {
+	var err error
 	if t.rollbackFunc != nil {
-		return t.rollbackFunc()
+		err = t.rollbackFunc()
 	}
-	return nil
+	t.finish("rollback", TxRolledBack, err)
+	return err
+}
+
+func (t *Tx) finish(op string, state TxState, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		state = TxFailed
+	}
+	// The first call decides the final state, later calls are only recorded so CheckTransactions can report them
+	if len(t.finishes) == 0 {
+		t.state = state
+	}
+	t.finishes = append(t.finishes, op)
+	t.savepoints = nil
+}
+
+// Returns the current state of the transaction, a transaction whose commit or rollback returned an error is TxFailed.
+func (t *Tx) State() TxState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.state
+}
+
+func (t *Tx) done() bool {
+	return t.State() != TxOpen
+}
+
+// Describes what's wrong with how the transaction was finished, or returns an empty string.
+func (t *Tx) problem() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	switch {
+	case len(t.finishes) == 0:
+		return fmt.Sprintf("transaction begun at %s was never committed or rolled back", t.createdAt)
+	case len(t.finishes) > 1:
+		return fmt.Sprintf("transaction begun at %s was finished %d times (%s)", t.createdAt, len(t.finishes), strings.Join(t.finishes, ", "))
+	}
+	return ""
 }
 
 func (t *Tx) SetCommitFunc(f func() error) {
@@ -38,3 +191,88 @@ func (t *Tx) StubRollbackError(err error) {
 		return err
 	})
 }
+
+// Stubs the transaction to return the supplied error when SAVEPOINT name is executed.
+func (t *Tx) StubSavepointError(name string, err error) {
+	t.stubSavepointError(SavepointCreate, name, err)
+}
+
+// Stubs the transaction to return the supplied error when RELEASE SAVEPOINT name is executed, the savepoint stays on the stack.
+func (t *Tx) StubReleaseSavepointError(name string, err error) {
+	t.stubSavepointError(SavepointRelease, name, err)
+}
+
+// Stubs the transaction to return the supplied error when ROLLBACK TO SAVEPOINT name is executed, the savepoint stack is left untouched.
+func (t *Tx) StubRollbackToSavepointError(name string, err error) {
+	t.stubSavepointError(SavepointRollback, name, err)
+}
+
+func (t *Tx) stubSavepointError(op SavepointOp, name string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.savepointErrs == nil {
+		t.savepointErrs = make(map[SavepointOp]map[string]error)
+	}
+	if t.savepointErrs[op] == nil {
+		t.savepointErrs[op] = make(map[string]error)
+	}
+	t.savepointErrs[op][strings.ToLower(name)] = err
+}
+
+// Returns the names of the savepoints currently active in the transaction, outermost first.
+func (t *Tx) Savepoints() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.savepoints...)
+}
+
+// Returns every savepoint statement executed in the transaction in the order they ran.
+func (t *Tx) SavepointHistory() []SavepointEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]SavepointEvent(nil), t.history...)
+}
+
+// Executes a savepoint statement against the transaction, ok is false if query isn't a savepoint statement.
+func (t *Tx) execSavepoint(query string) (res driver.Result, ok bool, err error) {
+	op, name, ok := parseSavepoint(query)
+	if !ok {
+		return nil, false, nil
+	}
+
+	t.mu.Lock()
+	err = t.savepointErrs[op][strings.ToLower(name)]
+	if err == nil {
+		err = t.applySavepoint(op, name)
+	}
+	t.history = append(t.history, SavepointEvent{Op: op, Name: name, Err: err})
+	t.mu.Unlock()
+
+	if err != nil {
+		return nil, true, err
+	}
+	return NewResult(0, nil, 0, nil), true, nil
+}
+
+// Must be called with mu held.
+func (t *Tx) applySavepoint(op SavepointOp, name string) error {
+	if op == SavepointCreate {
+		t.savepoints = append(t.savepoints, name)
+		return nil
+	}
+
+	// Savepoints are searched from the top of the stack so a reused name refers to the most recent one
+	for i := len(t.savepoints) - 1; i >= 0; i-- {
+		if strings.EqualFold(t.savepoints[i], name) {
+			if op == SavepointRelease {
+				t.savepoints = t.savepoints[:i]
+			} else {
+				t.savepoints = t.savepoints[:i+1]
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("savepoint %q does not exist", name)
+}
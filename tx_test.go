@@ -1,7 +1,9 @@
 package testdb
 
 import (
+	"database/sql"
 	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -56,3 +58,101 @@ func TestTxStubRollbackError(t *testing.T) {
 		t.Fatal("stubbed rollback did not return expected error")
 	}
 }
+
+func TestTxSavepoints(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+	tx, _ := db.Begin()
+
+	for _, q := range []string{"SAVEPOINT a", "savepoint b", "SAVEPOINT c", "ROLLBACK TO SAVEPOINT b", "RELEASE a"} {
+		if _, err := tx.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if sp := LastTx().Savepoints(); len(sp) != 0 {
+		t.Fatalf("expected empty savepoint stack, got %v", sp)
+	}
+
+	history := LastTx().SavepointHistory()
+	if len(history) != 5 || history[3].Op != SavepointRollback || history[3].Name != "b" {
+		t.Fatalf("unexpected savepoint history %v", history)
+	}
+
+	tx.Commit()
+}
+
+func TestTxSavepointStack(t *testing.T) {
+	tx := &Tx{}
+
+	tx.execSavepoint("SAVEPOINT a")
+	tx.execSavepoint(`SAVEPOINT "b"`)
+	tx.execSavepoint("SAVEPOINT c")
+	tx.execSavepoint("ROLLBACK TO b")
+
+	if sp := tx.Savepoints(); !reflect.DeepEqual(sp, []string{"a", "b"}) {
+		t.Fatalf("unexpected savepoint stack %v", sp)
+	}
+
+	if _, _, err := tx.execSavepoint("RELEASE SAVEPOINT c"); err == nil {
+		t.Fatal("releasing an unknown savepoint should fail")
+	}
+}
+
+func TestTxStubReleaseSavepointError(t *testing.T) {
+	tx := &Tx{}
+
+	tx.StubReleaseSavepointError("a", errors.New("release failed"))
+	tx.execSavepoint("SAVEPOINT a")
+	_, _, err := tx.execSavepoint("RELEASE SAVEPOINT a")
+
+	if err == nil || err.Error() != "release failed" {
+		t.Fatal("stubbed release did not return expected error")
+	}
+
+	if sp := tx.Savepoints(); len(sp) != 1 {
+		t.Fatal("failed release should leave the savepoint on the stack")
+	}
+}
+
+func TestStubRollbackToSavepointError(t *testing.T) {
+	defer Reset()
+
+	StubRollbackToSavepointError("a", errors.New("rollback to failed"))
+
+	db, _ := sql.Open("testdb", "")
+	tx, _ := db.Begin()
+	defer tx.Rollback()
+
+	tx.Exec("SAVEPOINT a")
+	_, err := tx.Exec("ROLLBACK TO SAVEPOINT a")
+
+	if err == nil || err.Error() != "rollback to failed" {
+		t.Fatal("stubbed rollback to savepoint did not return expected error")
+	}
+
+	if h := LastTx().SavepointHistory(); h[1].Err == nil {
+		t.Fatal("failed rollback to savepoint not recorded in history")
+	}
+}
+
+func TestTxSavepointsDuringRollback(t *testing.T) {
+	tx := &Tx{}
+	tx.execSavepoint("SAVEPOINT a")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tx.execSavepoint("SAVEPOINT b")
+		tx.Rollback()
+	}()
+
+	tx.Savepoints()
+	tx.SavepointHistory()
+	<-done
+
+	if sp := tx.Savepoints(); len(sp) != 0 {
+		t.Fatalf("rollback should clear the savepoint stack, got %v", sp)
+	}
+}
@@ -0,0 +1,248 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// What happens when a query or exec matches no handler or stub.
+type UnstubbedPolicy int
+
+const (
+	// The call returns an *ErrNotStubbed, the default
+	UnstubbedError UnstubbedPolicy = iota
+	// The test registered with FailUnstubbed() is failed, and the call returns an *ErrNotStubbed
+	UnstubbedFail
+	// The call panics with an *ErrNotStubbed
+	UnstubbedPanic
+	// Queries return no rows and execs affect no rows, the call is still recorded in Unstubbed()
+	UnstubbedEmpty
+)
+
+// The error returned for a query or exec that matches no handler or stub. Use errors.As() to get at the query and arguments.
+type ErrNotStubbed struct {
+	Query string
+	Args  []driver.NamedValue
+	Exec  bool
+	// The stubs closest to the query, most similar first
+	Suggestions []Suggestion
+}
+
+// A stub similar to a query that wasn't stubbed.
+type Suggestion struct {
+	// SQL the stub was registered with
+	SQL string
+	// From 0 to 1, how many tokens the stub and the query have in common
+	Similarity float64
+	// Token level diff from the stub to the query, removed tokens are shown as [-token-] and added ones as {+token+}. Empty when the tokens are identical.
+	Diff string
+	// Why a stub with identical SQL didn't match the call, such as "stubbed for Exec only"
+	Reason string
+}
+
+func (e *ErrNotStubbed) Error() string {
+	msg := "Query not stubbed: " + e.Query
+	if e.Exec {
+		msg = "Exec call not stubbed: " + e.Query
+	}
+	if len(e.Suggestions) > 0 {
+		msg += "\n\tdid you mean:"
+		for _, s := range e.Suggestions {
+			if s.Reason != "" {
+				msg += fmt.Sprintf("\n\t%s (%s)", s.SQL, s.Reason)
+			} else {
+				msg += fmt.Sprintf("\n\t%s (%.0f%% similar)\n\t\t%s", s.SQL, s.Similarity*100, s.Diff)
+			}
+		}
+	}
+	return msg
+}
+
+// Picks what happens when a query or exec matches no handler or stub.
+func SetUnstubbedPolicy(p UnstubbedPolicy) {
+	d.conn.SetUnstubbedPolicy(p)
+}
+
+// Fails tb as soon as a query or exec matches no handler or stub, rather than relying on the code under test to pass the error on.
+func FailUnstubbed(tb testing.TB) {
+	d.conn.FailUnstubbed(tb)
+}
+
+// Returns every query and exec since the last Reset that matched no handler or stub, whatever the policy.
+func Unstubbed() []*ErrNotStubbed {
+	return d.conn.Unstubbed()
+}
+
+// See the package level SetUnstubbedPolicy().
+func (c *Connector) SetUnstubbedPolicy(p UnstubbedPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unstubbedPolicy = p
+}
+
+// See the package level FailUnstubbed().
+func (c *Connector) FailUnstubbed(tb testing.TB) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.unstubbedPolicy = UnstubbedFail
+	c.unstubbedTB = tb
+}
+
+// See the package level Unstubbed().
+func (c *Connector) Unstubbed() []*ErrNotStubbed {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*ErrNotStubbed(nil), c.unstubbed...)
+}
+
+// Applies the unstubbed policy to a call, a nil error means it should return an empty result.
+func (c *conn) notStubbed(query string, args []driver.NamedValue, exec bool) error {
+	err := &ErrNotStubbed{Query: query, Args: args, Exec: exec, Suggestions: c.suggest(query, args, exec)}
+
+	c.mu.Lock()
+	c.unstubbed = append(c.unstubbed, err)
+	policy, tb := c.unstubbedPolicy, c.unstubbedTB
+	c.mu.Unlock()
+
+	switch policy {
+	case UnstubbedFail:
+		if tb != nil {
+			tb.Errorf("testdb: %v", err)
+		}
+	case UnstubbedPanic:
+		panic(err)
+	case UnstubbedEmpty:
+		return nil
+	}
+	return err
+}
+
+// Applies the unstubbed policy to a statement being prepared, an empty result lets it be prepared.
+func (c *conn) notStubbedPrepare(query string) error {
+	c.mu.Lock()
+	empty := c.unstubbedPolicy == UnstubbedEmpty
+	c.mu.Unlock()
+
+	if empty {
+		return nil
+	}
+	return c.notStubbed(query, nil, false)
+}
+
+// How many suggestions a not stubbed error shows at most, and how similar they must be.
+const (
+	maxSuggestions = 3
+	minSimilarity  = 0.5
+)
+
+// Returns the stubs most similar to query, comparing their tokens. Stubs with identical tokens are only suggested along with the reason they didn't match the call.
+func (c *conn) suggest(sql string, args []driver.NamedValue, exec bool) []Suggestion {
+	c.mu.Lock()
+	stubs := make(map[string][]*query)
+	var stubbed []string
+	for _, qs := range c.queries {
+		for _, q := range qs {
+			if stubs[q.sql] == nil {
+				stubbed = append(stubbed, q.sql)
+			}
+			stubs[q.sql] = append(stubs[q.sql], q)
+		}
+	}
+	c.mu.Unlock()
+
+	tokens := tokenize(sql)
+	var suggestions []Suggestion
+	for _, stub := range stubbed {
+		stubTokens := tokenize(stub)
+		similarity, diff := diffTokens(stubTokens, tokens)
+		if similarity < minSimilarity {
+			continue
+		}
+		if similarity < 1 {
+			suggestions = append(suggestions, Suggestion{SQL: stub, Similarity: similarity, Diff: diff})
+		} else if reason := c.mismatch(stubs[stub], args, exec); reason != "" {
+			suggestions = append(suggestions, Suggestion{SQL: stub, Similarity: similarity, Reason: reason})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Similarity != suggestions[j].Similarity {
+			return suggestions[i].Similarity > suggestions[j].Similarity
+		}
+		return suggestions[i].SQL < suggestions[j].SQL
+	})
+	if len(suggestions) > maxSuggestions {
+		suggestions = suggestions[:maxSuggestions]
+	}
+	return suggestions
+}
+
+// Describes why none of the stubs matched the call, or returns an empty string if that can't be told.
+func (c *conn) mismatch(stubs []*query, args []driver.NamedValue, exec bool) string {
+	var reasons []string
+	seen := make(map[string]bool)
+	for _, q := range stubs {
+		var reason string
+		switch {
+		case q.scenario != nil && q.scenario.State() != q.state:
+			reason = fmt.Sprintf("only in scenario %q state %q", q.scenario.Name(), q.state)
+		case q.args != nil && !c.argsMatch(q.args, args):
+			reason = fmt.Sprintf("stubbed with args %v", values(q.args))
+		case exec && q.result == nil && q.err == nil:
+			reason = "stubbed for Query only"
+		case !exec && q.rows == nil && q.err == nil:
+			reason = "stubbed for Exec only"
+		}
+		if reason != "" && !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	return strings.Join(reasons, ", ")
+}
+
+// Diffs two token lists using their longest common subsequence, returning how similar they are and the diff from a to b.
+func diffTokens(a, b []string) (float64, string) {
+	if len(a)+len(b) == 0 {
+		return 1, ""
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff = append(diff, a[i])
+			i++
+			j++
+		case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "[-"+a[i]+"-]")
+			i++
+		default:
+			diff = append(diff, "{+"+b[j]+"+}")
+			j++
+		}
+	}
+
+	return 2 * float64(lcs[0][0]) / float64(len(a)+len(b)), strings.Join(diff, " ")
+}
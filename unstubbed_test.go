@@ -0,0 +1,163 @@
+package testdb
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrNotStubbed(t *testing.T) {
+	defer Reset()
+
+	db, _ := sql.Open("testdb", "")
+
+	_, err := db.Exec("DELETE FROM users WHERE id = ?", 1)
+
+	var notStubbed *ErrNotStubbed
+	if !errors.As(err, &notStubbed) {
+		t.Fatal("expected an *ErrNotStubbed, got", err)
+	}
+	if notStubbed.Query != "DELETE FROM users WHERE id = ?" || !notStubbed.Exec || notStubbed.Args[0].Value != int64(1) {
+		t.Fatalf("unexpected error fields %+v", notStubbed)
+	}
+	if err.Error() != "Exec call not stubbed: DELETE FROM users WHERE id = ?" {
+		t.Fatal("unexpected error message", err)
+	}
+}
+
+func TestFailUnstubbed(t *testing.T) {
+	defer Reset()
+
+	tb := &fakeTB{TB: t}
+	FailUnstubbed(tb)
+
+	db, _ := sql.Open("testdb", "")
+
+	if _, err := db.Query("SELECT id FROM users"); err == nil {
+		t.Fatal("expected the query to still return an error")
+	}
+	if len(tb.errors) != 1 || tb.errors[0] != "testdb: Query not stubbed: SELECT id FROM users" {
+		t.Fatal("expected the test to be failed, got", tb.errors)
+	}
+}
+
+func TestUnstubbedPanic(t *testing.T) {
+	defer Reset()
+
+	SetUnstubbedPolicy(UnstubbedPanic)
+
+	db, _ := sql.Open("testdb", "")
+
+	defer func() {
+		if _, ok := recover().(*ErrNotStubbed); !ok {
+			t.Fatal("expected a panic with an *ErrNotStubbed")
+		}
+	}()
+	db.Exec("DELETE FROM users")
+}
+
+func TestUnstubbedEmpty(t *testing.T) {
+	defer Reset()
+
+	SetUnstubbedPolicy(UnstubbedEmpty)
+
+	db, _ := sql.Open("testdb", "")
+
+	rows, err := db.Query("SELECT id FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Fatal("expected no rows")
+	}
+	rows.Close()
+
+	res, err := db.Exec("DELETE FROM users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := res.RowsAffected(); n != 0 {
+		t.Fatal("expected no rows affected")
+	}
+
+	stmt, err := db.Prepare("SELECT name FROM users")
+	if err != nil {
+		t.Fatal("expected statements to be preparable", err)
+	}
+	stmt.Close()
+
+	if len(Unstubbed()) != 2 {
+		t.Fatal("expected unstubbed calls to be recorded", Unstubbed())
+	}
+}
+
+func TestNotStubbedSuggestions(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT id, name FROM users WHERE id = ?", RowsFromCSVString([]string{"id", "name"}, "1,tim"))
+	StubQuery("SELECT id FROM teams", RowsFromCSVString([]string{"id"}, "1"))
+	StubExec("DELETE FROM sessions", NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+
+	_, err := db.Query("SELECT id, name, email FROM users WHERE id = ?", 1)
+
+	var notStubbed *ErrNotStubbed
+	if !errors.As(err, &notStubbed) {
+		t.Fatal("expected an *ErrNotStubbed, got", err)
+	}
+	if len(notStubbed.Suggestions) != 1 {
+		t.Fatal("expected only the similar stub to be suggested", notStubbed.Suggestions)
+	}
+
+	best := notStubbed.Suggestions[0]
+	if best.SQL != "SELECT id, name FROM users WHERE id = ?" {
+		t.Fatal("expected the closest stub first, got", best.SQL)
+	}
+	if best.Diff != "select id , name {+,+} {+email+} from users where id = ?" {
+		t.Fatal("unexpected diff", best.Diff)
+	}
+
+	expected := "Query not stubbed: SELECT id, name, email FROM users WHERE id = ?\n" +
+		"\tdid you mean:\n" +
+		"\tSELECT id, name FROM users WHERE id = ? (91% similar)\n" +
+		"\t\tselect id , name {+,+} {+email+} from users where id = ?"
+	if msg := err.Error(); msg != expected {
+		t.Fatal("unexpected error message", msg)
+	}
+}
+
+func TestNotStubbedReasons(t *testing.T) {
+	defer Reset()
+
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+	StubQueryWithArgs("SELECT name FROM users WHERE id = ?", RowsFromCSVString([]string{"name"}, "tim"), 1)
+	sc := NewScenario("signup")
+	sc.StubQuery("registered", "SELECT count(*) FROM users", RowsFromCSVString([]string{"count"}, "1"))
+
+	db, _ := sql.Open("testdb", "")
+
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{queryErr(db, "DELETE FROM users"), "DELETE FROM users (stubbed for Exec only)"},
+		{queryErr(db, "SELECT name FROM users WHERE id = ?", 2), "SELECT name FROM users WHERE id = ? (stubbed with args [1])"},
+		{queryErr(db, "SELECT count(*) FROM users"), `SELECT count(*) FROM users (only in scenario "signup" state "registered")`},
+	}
+
+	for _, test := range tests {
+		if test.err == nil || !strings.HasSuffix(test.err.Error(), "did you mean:\n\t"+test.expected) {
+			t.Errorf("expected the suggestion to explain %q, got %v", test.expected, test.err)
+		}
+	}
+}
+
+func queryErr(db *sql.DB, query string, args ...interface{}) error {
+	rows, err := db.Query(query, args...)
+	if err == nil {
+		rows.Close()
+	}
+	return err
+}
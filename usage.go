@@ -0,0 +1,140 @@
+package testdb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+// How often a stub or handler was used since it was registered.
+type StubUsage struct {
+	// "stub", "query handler", "exec handler", "query func" or "exec func"
+	Kind string
+	// SQL and arguments the stub was registered with, empty for handlers
+	SQL  string
+	Args []driver.NamedValue
+	// Where the stub or handler was registered
+	Location string
+	// Number of calls it handled
+	Hits int
+
+	seq int64
+}
+
+func (u StubUsage) String() string {
+	if u.SQL == "" {
+		return fmt.Sprintf("%s added at %s", u.Kind, u.Location)
+	}
+	if u.Args != nil {
+		return fmt.Sprintf("stub for %q with args %v registered at %s", u.SQL, values(u.Args), u.Location)
+	}
+	return fmt.Sprintf("stub for %q registered at %s", u.SQL, u.Location)
+}
+
+func newHandlerUsage(kind string) *StubUsage {
+	return &StubUsage{Kind: kind, Location: callerLocation()}
+}
+
+// Must be called with mu held.
+func (c *Connector) register(usage *StubUsage) *StubUsage {
+	c.stubSeq++
+	usage.seq = c.stubSeq
+	return usage
+}
+
+func (c *Connector) hit(usage *StubUsage) {
+	if usage == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	usage.Hits++
+}
+
+// Returns every stub and handler registered since the last Reset along with how many calls it handled, in the order they were registered. Stubs that were replaced by a later stub for the same query and arguments aren't included.
+func StubUsages() []StubUsage {
+	return d.conn.StubUsages()
+}
+
+// Returns the stubs and handlers that haven't handled a single call since the last Reset.
+func UnusedStubs() []StubUsage {
+	return d.conn.UnusedStubs()
+}
+
+// Returns an error listing every stub and handler that hasn't handled a single call since the last Reset, so fixtures for queries the code no longer runs can be deleted.
+func CheckUnusedStubs() error {
+	return d.conn.CheckUnusedStubs()
+}
+
+// Registers a cleanup function with tb that fails the test if any stub or handler was never used during it.
+func FailOnUnusedStubs(tb testing.TB) {
+	d.conn.FailOnUnusedStubs(tb)
+}
+
+// Makes the cleanup registered by Setup() or WithT() also report unused stubs, see CheckUnusedStubs().
+func WithUnusedStubCheck() Option {
+	return func(c *Connector) {
+		c.checkUnused = true
+	}
+}
+
+// See the package level StubUsages().
+func (c *Connector) StubUsages() []StubUsage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var usages []StubUsage
+	for _, qs := range c.queries {
+		for _, q := range qs {
+			usages = append(usages, *q.usage)
+		}
+	}
+	for _, h := range c.queryHandlers {
+		usages = append(usages, *h.usage)
+	}
+	for _, h := range c.execHandlers {
+		usages = append(usages, *h.usage)
+	}
+	if c.queryFunc != nil {
+		usages = append(usages, *c.queryFuncUsage)
+	}
+	if c.execFunc != nil {
+		usages = append(usages, *c.execFuncUsage)
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].seq < usages[j].seq
+	})
+	return usages
+}
+
+// See the package level UnusedStubs().
+func (c *Connector) UnusedStubs() []StubUsage {
+	var unused []StubUsage
+	for _, u := range c.StubUsages() {
+		if u.Hits == 0 {
+			unused = append(unused, u)
+		}
+	}
+	return unused
+}
+
+// See the package level CheckUnusedStubs().
+func (c *Connector) CheckUnusedStubs() error {
+	var problems []string
+	for _, u := range c.UnusedStubs() {
+		problems = append(problems, u.String()+" was never used")
+	}
+	return problemsError(problems)
+}
+
+// See the package level FailOnUnusedStubs().
+func (c *Connector) FailOnUnusedStubs(tb testing.TB) {
+	tb.Cleanup(func() {
+		if err := c.CheckUnusedStubs(); err != nil {
+			tb.Error(err)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package testdb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestStubUsages(t *testing.T) {
+	defer Reset()
+
+	StubQuery("SELECT id FROM users", RowsFromCSVString([]string{"id"}, "1"))
+	StubQuery("SELECT id FROM teams", RowsFromCSVString([]string{"id"}, "1"))
+	StubExecWithArgs("DELETE FROM users WHERE id = ?", NewResult(0, nil, 1, nil), 1)
+	AddQueryHandler(func(query string, args []driver.NamedValue) (driver.Rows, error) {
+		return nil, ErrNotHandled
+	})
+
+	db, _ := sql.Open("testdb", "")
+
+	for i := 0; i < 2; i++ {
+		rows, _ := db.Query("SELECT id FROM users")
+		rows.Close()
+	}
+	db.Exec("DELETE FROM users WHERE id = ?", 1)
+
+	usages := StubUsages()
+	if len(usages) != 4 {
+		t.Fatal("expected every stub and handler to be listed", usages)
+	}
+	if usages[0].SQL != "SELECT id FROM users" || usages[0].Hits != 2 {
+		t.Fatalf("unexpected usage %+v", usages[0])
+	}
+	if usages[2].Hits != 1 || usages[3].Kind != "query handler" || usages[3].Hits != 0 {
+		t.Fatal("unexpected usages", usages)
+	}
+
+	unused := UnusedStubs()
+	if len(unused) != 2 || unused[0].SQL != "SELECT id FROM teams" {
+		t.Fatal("expected the teams stub and the handler to be unused", unused)
+	}
+
+	err := CheckUnusedStubs()
+	if err == nil || !regexp.MustCompile(`stub for "SELECT id FROM teams" registered at \S*usage_test.go:\d+ was never used\n\tquery handler added at \S*usage_test.go:\d+ was never used`).MatchString(err.Error()) {
+		t.Fatal("expected the unused stubs to be reported with where they were registered, got", err)
+	}
+}
+
+func TestStubUsagesWrongKind(t *testing.T) {
+	defer Reset()
+
+	StubExec("DELETE FROM users", NewResult(0, nil, 1, nil))
+
+	db, _ := sql.Open("testdb", "")
+
+	if _, err := db.Query("DELETE FROM users"); err == nil {
+		t.Fatal("an exec stub should not handle a query")
+	}
+	if unused := UnusedStubs(); len(unused) != 1 {
+		t.Fatal("a stub that didn't handle the call should not count as used", unused)
+	}
+}
+
+func TestSetupWithUnusedStubCheck(t *testing.T) {
+	tb := &fakeTB{TB: t}
+	Setup(tb, WithUnusedStubCheck())
+
+	SetExecFunc(func(query string) (driver.Result, error) {
+		return NewResult(0, nil, 1, nil), nil
+	})
+
+	tb.finish()
+
+	if len(tb.errors) != 1 || !strings.Contains(tb.errors[0], "exec func added at") {
+		t.Fatal("expected the unused exec func to be reported", tb.errors)
+	}
+}